@@ -81,7 +81,11 @@ func (p *printer) prettyPrint(node Node) {
 				p.printf("} else {\n")
 				p.prettyPrint(stmt.Else)
 			}
-			p.printf("}\n")
+			if stmt.Fi != nil {
+				p.printf("} fi %v\n", stmt.Fi.ExprStr())
+			} else {
+				p.printf("}\n")
+			}
 
 		case *ForStmt:
 			if stmt.Init == nil {
@@ -97,6 +101,9 @@ func (p *printer) prettyPrint(node Node) {
 			for _, seize := range stmt.Seizes {
 				p.printf("%v\n", seize.StmtStr())
 			}
+			if stmt.Always != nil {
+				p.printf("ALWAYS:\n%v\n", stmt.Always.StmtStr())
+			}
 
 		default:
 			p.printf("%v\n", stmt.StmtStr())
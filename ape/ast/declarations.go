@@ -48,9 +48,13 @@ type FuncDecl struct {
 	Params     []*ParamDecl
 	ReturnType *TypeExpr
 	Body       *BlockStmt
+	Rev        bool // a rev func can be uncalled by running its body inverted
 }
 
 func (d *FuncDecl) DeclStr() string {
+	if d.Rev {
+		return fmt.Sprintf("(decl rev func %v)", d.Name.Lexeme)
+	}
 	return fmt.Sprintf("(decl func %v)", d.Name.Lexeme)
 }
 
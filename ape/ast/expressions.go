@@ -100,6 +100,16 @@ func (e *CallExpr) ExprStr() string {
 	return fmt.Sprintf("(%v() %v)", e.Callee.ExprStr(), exprListStr(e.Args))
 }
 
+/** spawn f(x), which runs the call as a task of its own */
+type SpawnExpr struct {
+	Token token.Token
+	Call  *CallExpr
+}
+
+func (e *SpawnExpr) ExprStr() string {
+	return fmt.Sprintf("(spawn %v)", e.Call.ExprStr())
+}
+
 type DotExpr struct {
 	Expr  Expression
 	Field *IdentExpr
@@ -121,13 +131,18 @@ func (e *IndexExpr) ExprStr() string {
 type TypeExpr struct {
 	Name string
 	List bool
+	Chan bool // a channel of the named type, or of lists of it
 }
 
 func (e *TypeExpr) ExprStr() string {
+	name := e.Name
 	if e.List {
-		return fmt.Sprint("[]", e.Name)
+		name = fmt.Sprint("[]", name)
+	}
+	if e.Chan {
+		return fmt.Sprint("chan ", name)
 	}
-	return e.Name
+	return name
 }
 
 type LitListExpr struct {
@@ -151,3 +166,37 @@ func (e *LitMapExpr) ExprStr() string {
 	sb.WriteString("}")
 	return sb.String()
 }
+
+// Mentions reports whether an expression uses the variable name
+func Mentions(expr Expression, name string) bool {
+	switch e := expr.(type) {
+	case *IdentExpr:
+		return e.Ident.Lexeme == name
+	case *GroupExpr:
+		return Mentions(e.Expr, name)
+	case *UnaryOp:
+		return Mentions(e.Expr, name)
+	case *BinaryOp:
+		return Mentions(e.Lhs, name) || Mentions(e.Rhs, name)
+	case *IndexExpr:
+		return Mentions(e.Expr, name) || Mentions(e.Index, name)
+	case *DotExpr:
+		return Mentions(e.Expr, name)
+	case *SpawnExpr:
+		return Mentions(e.Call, name)
+	case *CallExpr:
+		for _, arg := range e.Args {
+			if Mentions(arg, name) {
+				return true
+			}
+		}
+		return Mentions(e.Callee, name)
+	case *LitListExpr:
+		for _, el := range e.Elements {
+			if Mentions(el, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package ast
+
+import (
+	"fmt"
+
+	"github.com/pcen/ape/ape/token"
+)
+
+// inverses of the reversible assignment operators
+var invertedAssignment = map[token.Kind]token.Kind{
+	token.PlusEq:  token.MinusEq,
+	token.MinusEq: token.PlusEq,
+	token.CaretEq: token.CaretEq,
+}
+
+// Invert returns the statement that undoes stmt, which must only use reversible updates:
+// +=, -=, ^=, swaps, calls and uncalls, and ifs with a fi assertion. Blocks run their
+// inverted statements in reverse order, and an inverted if tests its fi assertion and
+// asserts its condition.
+func Invert(stmt Statement) Statement {
+	switch s := stmt.(type) {
+	case *BlockStmt:
+		content := make([]Statement, len(s.Content))
+		for i, stmt := range s.Content {
+			content[len(content)-1-i] = Invert(stmt)
+		}
+		return &BlockStmt{Content: content}
+
+	case *AssignmentStmt:
+		op, ok := invertedAssignment[s.Op.Kind]
+		if !ok {
+			panic(fmt.Sprintf("cannot invert assignment %v", s.StmtStr()))
+		}
+		return NewAssignmentStmt(s.Lhs, token.New(op, s.Op.Position), s.Rhs.(*BinaryOp).Rhs)
+
+	case *SwapStmt:
+		return s
+
+	case *CallStmt:
+		kind := token.Uncall
+		if s.Uncall() {
+			kind = token.Call
+		}
+		return &CallStmt{Token: token.New(kind, s.Token.Position), Call: s.Call}
+
+	case *IfStmt:
+		if s.Fi == nil || len(s.Elifs) > 0 {
+			panic(fmt.Sprintf("cannot invert %v without a fi assertion or with elifs", s.StmtStr()))
+		}
+		inverted := &IfStmt{
+			If: &CondBlockStmt{Cond: s.Fi, Body: Invert(s.If.Body).(*BlockStmt)},
+			Fi: s.If.Cond,
+		}
+		if s.Else != nil {
+			inverted.Else = Invert(s.Else).(*BlockStmt)
+		}
+		return inverted
+
+	default:
+		panic(fmt.Sprintf("cannot invert %v", stmt.StmtStr()))
+	}
+}
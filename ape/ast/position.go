@@ -0,0 +1,80 @@
+package ast
+
+import "github.com/pcen/ape/ape/token"
+
+// Pos returns the position of the first token found in a statement or expression,
+// or the zero position for nodes without one, like break. Lines are exact, but
+// columns are where the token ends.
+func Pos(node Node) token.Position {
+	switch n := node.(type) {
+	case *BlockStmt:
+		if len(n.Content) > 0 {
+			return Pos(n.Content[0])
+		}
+	case *ExprStmt:
+		return Pos(n.Expr)
+	case *ReturnStmt:
+		if n.Expr != nil {
+			return Pos(n.Expr)
+		}
+	case *TypedDeclStmt:
+		return n.Decl.Ident.Position
+	case *IfStmt:
+		return Pos(n.If.Cond)
+	case *CondBlockStmt:
+		return Pos(n.Cond)
+	case *ForStmt:
+		if decl, ok := n.Init.(*VarDecl); ok {
+			return decl.Ident.Position
+		}
+		return Pos(n.Cond)
+	case *IncStmt:
+		return n.Op.Position
+	case *AssignmentStmt:
+		return Pos(n.Lhs)
+	case *SwitchStmt:
+		return n.Token.Position
+	case *CaseStmt:
+		return n.Token.Position
+	case *SkipStmt:
+		return n.Token.Position
+	case *SeizeStmt:
+		return n.Token.Position
+	case *ReverseStmt:
+		return n.Token.Position
+	case *SavepointStmt:
+		return n.Token.Position
+	case *RetryStmt:
+		return n.Token.Position
+	case *CommitStmt:
+		return n.Token.Position
+	case *SwapStmt:
+		return n.Token.Position
+	case *CallStmt:
+		return n.Token.Position
+
+	case *IdentExpr:
+		return n.Ident.Position
+	case *LiteralExpr:
+		return n.Position
+	case *GroupExpr:
+		return Pos(n.Expr)
+	case *UnaryOp:
+		return Pos(n.Expr)
+	case *BinaryOp:
+		return Pos(n.Lhs)
+	case *CallExpr:
+		return Pos(n.Callee)
+	case *SpawnExpr:
+		return n.Token.Position
+	case *DotExpr:
+		return Pos(n.Expr)
+	case *IndexExpr:
+		return Pos(n.Expr)
+	case *LitListExpr:
+		if len(n.Elements) > 0 {
+			return Pos(n.Elements[0])
+		}
+	}
+	return token.Position{}
+}
@@ -33,7 +33,8 @@ func (s *BlockStmt) StmtStr() string {
 
 type ExprStmt struct {
 	// Currently, only expression statements (more specifically, function calls)
-	// can have annotations, and only the "reverse" annotation is implemented
+	// and declarations can have annotations, and only the "undo" annotation is
+	// implemented. Its arguments can refer to the value of Expr as it
 	Annotations map[string]Statement
 	Expr        Expression
 }
@@ -51,7 +52,8 @@ func (s *ReturnStmt) StmtStr() string {
 }
 
 type TypedDeclStmt struct {
-	Decl *VarDecl
+	Decl        *VarDecl
+	Annotations map[string]Statement // id := create() @undo destroy(it)
 }
 
 func (s *TypedDeclStmt) StmtStr() string {
@@ -73,6 +75,7 @@ type IfStmt struct {
 	If    *CondBlockStmt
 	Elifs []*CondBlockStmt
 	Else  *BlockStmt
+	Fi    Expression // exit assertion, true after the if body and false after the else body
 }
 
 func (s *IfStmt) StmtStr() string {
@@ -108,6 +111,7 @@ func (s *IncStmt) StmtStr() string {
 type AssignmentStmt struct {
 	Lhs Expression
 	Rhs Expression
+	Op  token.Token // the assignment operator as written, Rhs already applies the binary op of +=, -=, ...
 }
 
 var assignmentToBinaryOp = map[token.Kind]token.Kind{
@@ -117,14 +121,15 @@ var assignmentToBinaryOp = map[token.Kind]token.Kind{
 	token.DivideEq: token.Divide,
 	token.PowerEq:  token.Power,
 	token.ModEq:    token.Mod,
+	token.CaretEq:  token.Caret,
 }
 
 func NewAssignmentStmt(lhs Expression, op token.Token, rhs Expression) *AssignmentStmt {
 	if op.Kind != token.Assign {
-		op = token.New(assignmentToBinaryOp[op.Kind], op.Position)
-		rhs = &BinaryOp{Lhs: lhs, Op: op, Rhs: rhs}
+		binOp := token.New(assignmentToBinaryOp[op.Kind], op.Position)
+		rhs = &BinaryOp{Lhs: lhs, Op: binOp, Rhs: rhs}
 	}
-	return &AssignmentStmt{Lhs: lhs, Rhs: rhs}
+	return &AssignmentStmt{Lhs: lhs, Rhs: rhs, Op: op}
 }
 
 func (s *AssignmentStmt) StmtStr() string {
@@ -173,22 +178,33 @@ func (s *FallthroughtStmt) StmtStr() string {
 }
 
 type SkipStmt struct {
-	Token  token.Token
-	Body   *BlockStmt
-	Seizes []*SeizeStmt
+	Atomic  *token.Token // the atomic keyword of a skip block that runs as a transaction, nil otherwise
+	Token   token.Token
+	Body    *BlockStmt
+	Seizes  []*SeizeStmt
+	Retries []*RetryStmt // retry statements in the seizes that run Body again
+	Always  *BlockStmt   // runs last however the skip is left, nil without an always clause
 }
 
 func (s *SkipStmt) StmtStr() string {
+	if s.Atomic != nil {
+		return "ATOMIC SKIP:\n" + s.Body.StmtStr()
+	}
 	return "SKIP:\n" + s.Body.StmtStr()
 }
 
 type SeizeStmt struct {
 	Token token.Token
-	Expr  Expression
+	Expr  Expression // value seized, nil when seizing by type or seizing everything
+	Name  *IdentExpr // bound to the reversed value when seizing by type
+	Type  *TypeExpr
 	Body  *BlockStmt
 }
 
 func (s *SeizeStmt) StmtStr() string {
+	if s.Name != nil {
+		return "SEIZE: " + s.Name.ExprStr() + ": " + s.Type.ExprStr() + "\n" + s.Body.StmtStr()
+	}
 	if s.Expr != nil {
 		return "SEIZE: " + s.Expr.ExprStr() + "\n" + s.Body.StmtStr()
 	}
@@ -198,11 +214,72 @@ func (s *SeizeStmt) StmtStr() string {
 type ReverseStmt struct {
 	Token token.Token
 	Expr  Expression
+	To    Expression // savepoint in "reverse to x", nil when the whole skip is reversed
 }
 
 func (s *ReverseStmt) StmtStr() string {
+	if s.To != nil {
+		return "(REVERSE TO " + s.To.ExprStr() + ")"
+	}
 	if s.Expr != nil {
 		return "(REVERSE " + s.Expr.ExprStr() + ")"
 	}
 	return "(REVERSE)"
 }
+
+type SavepointStmt struct {
+	Token token.Token
+	Name  Expression
+}
+
+func (s *SavepointStmt) StmtStr() string {
+	return "(SAVEPOINT " + s.Name.ExprStr() + ")"
+}
+
+type RetryStmt struct {
+	Token   token.Token
+	Counter *IdentExpr // bound to the attempt number in the skip body and its seizes, or nil
+	Max     Expression // maximum number of attempts, or nil to retry without limit
+}
+
+func (s *RetryStmt) StmtStr() string {
+	if s.Counter != nil {
+		return "(RETRY " + s.Counter.ExprStr() + ": " + s.Max.ExprStr() + ")"
+	} else if s.Max != nil {
+		return "(RETRY " + s.Max.ExprStr() + ")"
+	}
+	return "(RETRY)"
+}
+
+type CommitStmt struct {
+	Token token.Token
+}
+
+func (s *CommitStmt) StmtStr() string {
+	return "(COMMIT)"
+}
+
+// SwapStmt exchanges the values of two assignable expressions
+type SwapStmt struct {
+	Token token.Token
+	Lhs   Expression
+	Rhs   Expression
+}
+
+func (s *SwapStmt) StmtStr() string {
+	return fmt.Sprintf("(swap %v %v)", s.Lhs.ExprStr(), s.Rhs.ExprStr())
+}
+
+// CallStmt runs a rev func forwards with call, or its inverse with uncall
+type CallStmt struct {
+	Token token.Token
+	Call  *CallExpr
+}
+
+func (s *CallStmt) Uncall() bool {
+	return s.Token.Kind == token.Uncall
+}
+
+func (s *CallStmt) StmtStr() string {
+	return fmt.Sprintf("(%v %v)", s.Token.Kind, s.Call.ExprStr())
+}
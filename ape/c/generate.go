@@ -58,9 +58,11 @@ func newVectorLiteralFunction(t types.Type) (string, bool) {
 func GenerateCode(decls []ast.Declaration, env types.Environment) *codegen {
 	cg := newCodegen(env)
 	cg.write(builtins)
+	cg.write(reverseRuntime)
 	cg.write(implementVector(vectorImplementations[types.IntList], "int"))
 	cg.write(implementVector(vectorImplementations[types.StringList], "char*"))
 	cg.program(decls)
+	cg.write(cg.thunks.String())
 	return cg
 }
 
@@ -68,6 +70,15 @@ type codegen struct {
 	Code  *strings.Builder
 	Env   types.Environment
 	level int
+
+	fn      string          // function being generated
+	globals map[string]bool // global variables
+	locals  map[string]bool // variables declared in the function being generated
+	leaves  bool            // the function has skips, so its saved locals are dropped on return
+	skips   []frame         // ape_skip frames the statement being generated is in, innermost last
+	loops   int             // loops entered since the innermost skip
+	ids     int
+	thunks  strings.Builder // functions making the calls of @undo annotations
 }
 
 func (cg *codegen) TypeOf(expr ast.Expression) types.Type {
@@ -79,8 +90,10 @@ func (cg *codegen) TypeOf(expr ast.Expression) types.Type {
 
 func newCodegen(env types.Environment) *codegen {
 	return &codegen{
-		Code: &strings.Builder{},
-		Env:  env,
+		Code:    &strings.Builder{},
+		Env:     env,
+		globals: make(map[string]bool),
+		locals:  make(map[string]bool),
 	}
 }
 
@@ -227,6 +240,8 @@ func (cg *codegen) expr(expr ast.Expression) {
 		// check for method call
 		if dot, ok := e.Callee.(*ast.DotExpr); ok {
 			cg.method(dot, e)
+		} else if ident, ok := e.Callee.(*ast.IdentExpr); ok && ident.Ident.Lexeme == "println" {
+			cg.println(e.Args)
 		} else {
 			cg.expr(e.Callee)
 			cg.args(e.Args)
@@ -270,6 +285,9 @@ func (cg *codegen) expr(expr ast.Expression) {
 		cg.write(length)
 		cg.write(")")
 
+	case *ast.SpawnExpr:
+		panic("c backend: spawn is not supported")
+
 	default:
 		panic("cannot gen expr of type " + reflect.TypeOf(expr).String())
 	}
@@ -278,12 +296,40 @@ func (cg *codegen) expr(expr ast.Expression) {
 func (cg *codegen) stmt(stmt ast.Statement) {
 	switch t := stmt.(type) {
 	case *ast.ExprStmt:
-		cg.expr(t.Expr)
+		undo, ok := t.Annotations["undo"]
+		switch {
+		case !ok:
+			cg.expr(t.Expr)
+		case mentionsIt(undo):
+			cg.write("{ " + cg.typstr(cg.TypeOf(t.Expr)) + " it = ")
+			cg.expr(t.Expr)
+			cg.write("; ")
+			cg.compensation(undo)
+			cg.write(" }")
+		default:
+			cg.compensation(undo)
+			cg.write(";\n")
+			cg.indent()
+			cg.expr(t.Expr)
+		}
 
 	case *ast.TypedDeclStmt:
+		undo, ok := t.Annotations["undo"]
+		if ok && !mentionsIt(undo) {
+			cg.compensation(undo)
+			cg.write(";\n")
+			cg.indent()
+		}
 		cg.decl(t.Decl)
+		if ok && mentionsIt(undo) {
+			cg.write(";\n")
+			cg.sil(fmt.Sprintf("{ %v it = %v; ", cg.typstr(cg.TypeOf(t.Decl.Value)), t.Decl.Ident.Lexeme))
+			cg.compensation(undo)
+			cg.write(" }")
+		}
 
 	case *ast.AssignmentStmt:
+		cg.save(t.Lhs)
 		cg.gen(t.Lhs)
 		cg.write(" = ")
 		cg.gen(t.Rhs)
@@ -327,12 +373,15 @@ func (cg *codegen) stmt(stmt ast.Statement) {
 			cg.stmt(t.Incr)
 		}
 		cg.write(") {\n")
+		cg.loops++
 		cg.indented(func() {
 			cg.stmt(t.Body)
 		})
+		cg.loops--
 		cg.sil("}")
 
 	case *ast.IncStmt:
+		cg.save(t.Expr)
 		cg.expr(t.Expr)
 		if t.Op.Kind == token.Increment {
 			cg.write("++")
@@ -341,8 +390,20 @@ func (cg *codegen) stmt(stmt ast.Statement) {
 		}
 
 	case *ast.BreakStmt:
+		if len(cg.skips) > 0 && cg.loops == 0 {
+			panic("c backend: break out of a skip block is not supported")
+		}
 		cg.write("break")
 
+	case *ast.ReturnStmt:
+		cg.ret(t)
+
+	case *ast.SkipStmt:
+		cg.skip(t)
+
+	case *ast.ReverseStmt:
+		cg.reverse(t)
+
 	case *ast.SwitchStmt:
 		cg.write("switch (")
 		cg.expr(t.Expr)
@@ -411,7 +472,7 @@ func (cg *codegen) args(exprs []ast.Expression) {
 func (cg *codegen) params(decls []*ast.ParamDecl) {
 	cg.write("(")
 	for i, pd := range decls {
-		cg.write(pd.Type.Name + " ")
+		cg.write(cg.typstr(cg.TypeOf(pd.Type)) + " ")
 		cg.expr(pd.Ident)
 		if i != len(decls)-1 {
 			cg.write(", ")
@@ -448,6 +509,13 @@ func (cg *codegen) decl(decl ast.Declaration) {
 
 	case *ast.FuncDecl:
 		cg.write("\n")
+		cg.fn = d.Name.Lexeme
+		cg.locals = make(map[string]bool)
+		for _, param := range d.Params {
+			cg.locals[param.Ident.Ident.Lexeme] = true
+		}
+		declareLocals(d.Body, cg.locals)
+		cg.leaves = cg.fn != "main" && containsSkip(d.Body)
 		if d.Name.Lexeme == "main" {
 			cg.write("int main(int c_argc, char* c_argv[]) {\n")
 			cg.level++
@@ -460,15 +528,21 @@ func (cg *codegen) decl(decl ast.Declaration) {
 			cg.level--
 			cg.write("}\n")
 		} else {
-			typ := "void"
+			typ := types.Type(types.Void)
 			if d.ReturnType != nil {
-				typ = d.ReturnType.Name
+				typ = types.LookupPrimitive(d.ReturnType.Name)
 			}
-			cg.write(typ + " " + d.Name.Lexeme)
+			cg.write(cg.typstr(typ) + " " + d.Name.Lexeme)
 			cg.params(d.Params)
 			cg.write(" {\n")
 			cg.level++
+			if cg.leaves {
+				cg.sil("int ape_mark = ape_log_len;\n")
+			}
 			cg.gen(d.Body)
+			if cg.leaves {
+				cg.sil("ape_leave(ape_mark);\n")
+			}
 			cg.level--
 			cg.write("}\n")
 		}
@@ -493,6 +567,106 @@ func (cg *codegen) gen(node ast.Node) {
 
 func (cg *codegen) program(decls []ast.Declaration) {
 	for _, d := range decls {
-		cg.decl(d)
+		switch t := d.(type) {
+		case *ast.VarDecl:
+			cg.globals[t.Ident.Lexeme] = true
+			cg.decl(d)
+			cg.write(";\n")
+		default:
+			cg.decl(d)
+		}
+	}
+}
+
+// ret returns from the function being generated, ending the skips the return leaves and
+// running their always clauses, innermost first
+func (cg *codegen) ret(r *ast.ReturnStmt) {
+	main := cg.fn == "main"
+	if len(cg.skips) == 0 && (main || !cg.leaves) {
+		if main {
+			// the value main returns is not the exit code of the program, as in the interpreter
+			if r.Expr != nil {
+				cg.write("(void)(")
+				cg.expr(r.Expr)
+				cg.write("); ")
+			}
+			cg.write("return 0")
+			return
+		}
+		cg.write("return")
+		if r.Expr != nil {
+			cg.write(" ")
+			cg.expr(r.Expr)
+		}
+		return
+	}
+	cg.write("{\n")
+	cg.indented(func() {
+		if r.Expr != nil {
+			cg.indent()
+			if main {
+				cg.write("(void)(")
+				cg.expr(r.Expr)
+				cg.write(");\n")
+			} else {
+				cg.write(cg.typstr(cg.TypeOf(r.Expr)) + " ape_ret = ")
+				cg.expr(r.Expr)
+				cg.write(";\n")
+			}
+		}
+		frames := cg.skips
+		for i := len(frames) - 1; i >= 0; i-- {
+			cg.sil("ape_skip_end(&" + frames[i].name + ");\n")
+			if frames[i].always != nil {
+				cg.skips = frames[:i]
+				cg.always(frames[i].always)
+			}
+		}
+		cg.skips = frames
+		if cg.leaves {
+			cg.sil("ape_leave(ape_mark);\n")
+		}
+		switch {
+		case main:
+			cg.sil("return 0;\n")
+		case r.Expr != nil:
+			cg.sil("return ape_ret;\n")
+		default:
+			cg.sil("return;\n")
+		}
+	})
+	cg.sil("}")
+}
+
+// println writes its arguments next to each other, formatted like the interpreter formats them
+func (cg *codegen) println(args []ast.Expression) {
+	formats := make([]string, 0, len(args))
+	for _, arg := range args {
+		switch t := cg.TypeOf(arg); {
+		case t.Is(types.String), t.Is(types.Bool):
+			formats = append(formats, "%s")
+		case t.Is(types.Int), t.Is(types.Float), t.Is(types.Double):
+			// rationals print their integer part
+			formats = append(formats, "%d")
+		default:
+			panic("c backend: cannot print values of type " + t.String())
+		}
+	}
+	cg.write(fmt.Sprintf(`printf("%v\n"`, strings.Join(formats, "")))
+	for _, arg := range args {
+		cg.write(", ")
+		switch t := cg.TypeOf(arg); {
+		case t.Is(types.Bool):
+			cg.write("(")
+			cg.expr(arg)
+			cg.write(`) ? "True" : "False"`)
+		case t.Is(types.Float), t.Is(types.Double):
+			cg.write("(int)(")
+			cg.expr(arg)
+			cg.write(")")
+		default:
+			cg.expr(arg)
+		}
 	}
+	cg.write(")")
 }
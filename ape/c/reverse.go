@@ -0,0 +1,464 @@
+package c
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pcen/ape/ape/ast"
+	"github.com/pcen/ape/ape/types"
+)
+
+/*
+ Code generation for skip blocks. Each skip keeps a mark into an undo log of
+ variable snapshots and @undo compensations, and a jump buffer that reverse
+ longjmps to once it has unwound the log back to the mark. The output is not
+ preprocessed, so gcc's builtin setjmp is used rather than <setjmp.h>. The seizes of the skip then
+ compare the reversed value, reversing the enclosing skip if none of them match.
+ Variables are restored through their address, which relies on gcc not keeping
+ them in registers across setjmp, as it does not without optimizations.
+*/
+
+const reverseRuntime = `int strcmp(const char*, const char*);
+void* memcpy(void*, const void*, unsigned long);
+void free(void*);
+void exit(int);
+
+/* a value passed to reverse, or an argument saved for a compensation */
+typedef struct ape_value {
+	int kind; /* 0 void, 1 int, 2 bool, 3 string, 4 rational */
+	int i;
+	double d;
+	char* s;
+} ape_value;
+
+ape_value ape_void() { ape_value v = {0}; return v; }
+ape_value ape_int(int i) { ape_value v = {1}; v.i = i; return v; }
+ape_value ape_bool(int b) { ape_value v = {2}; v.i = b; return v; }
+ape_value ape_str(char* s) { ape_value v = {3}; v.s = s; return v; }
+ape_value ape_rational(double d) { ape_value v = {4}; v.d = d; return v; }
+
+int ape_equals(ape_value a, ape_value b) {
+	if (a.kind != b.kind) {
+		return 0;
+	}
+	switch (a.kind) {
+	case 3:
+		return strcmp(a.s, b.s) == 0;
+	case 4:
+		return a.d == b.d;
+	default:
+		return a.i == b.i;
+	}
+}
+
+/* a snapshot of a variable to restore, or a compensation to call with its saved arguments */
+typedef struct ape_undo {
+	void* addr;
+	void* saved;
+	unsigned long size;
+	void (*compensate)(void*);
+	int local; /* addr is on the stack of the function that saved it */
+} ape_undo;
+
+ape_undo* ape_log;
+int ape_log_len;
+int ape_log_cap;
+
+typedef struct ape_skip {
+	void* env[5]; /* for __builtin_setjmp */
+	int mark; /* length of the undo log when the skip started */
+	ape_value reversed;
+	int guard; /* reversing it only jumps back, the enclosing skip unwinds the undo log */
+	struct ape_skip* prev;
+} ape_skip;
+
+ape_skip* ape_skips;
+int ape_reversing;
+
+static void ape_log_push(ape_undo u) {
+	if (ape_log_len == ape_log_cap) {
+		ape_log_cap = ape_log_cap ? ape_log_cap * 2 : 16;
+		ape_log = realloc(ape_log, sizeof(ape_undo) * ape_log_cap);
+	}
+	ape_log[ape_log_len++] = u;
+}
+
+/* saves the bytes of a variable before it is assigned inside a skip */
+void ape_save(void* addr, unsigned long size, int local) {
+	if (!ape_skips || ape_reversing) {
+		return;
+	}
+	ape_undo u = {addr, malloc(size), size, 0, local};
+	memcpy(u.saved, addr, size);
+	ape_log_push(u);
+}
+
+/* registers the compensation of an @undo annotation, which owns args */
+void ape_on_reverse(void (*compensate)(void*), void* args) {
+	if (!ape_skips || ape_reversing) {
+		free(args);
+		return;
+	}
+	ape_undo u = {0, args, 0, compensate, 0};
+	ape_log_push(u);
+}
+
+void ape_skip_begin(ape_skip* s) {
+	s->mark = ape_log_len;
+	s->guard = 0;
+	s->prev = ape_skips;
+	ape_skips = s;
+}
+
+/* guards the seizes of a skip with an always clause, so a reversal in them runs the always
+   clause before the enclosing skip unwinds what the seizes and the always clause did */
+void ape_guard_begin(ape_skip* s) {
+	ape_skip_begin(s);
+	s->guard = 1;
+}
+
+/* the undo log of a finished skip belongs to the enclosing one, the outermost drops it */
+void ape_skip_end(ape_skip* s) {
+	ape_skips = s->prev;
+	if (ape_skips) {
+		return;
+	}
+	for (int i = 0; i < ape_log_len; i++) {
+		free(ape_log[i].saved);
+	}
+	ape_log_len = 0;
+}
+
+void ape_reverse(ape_value v) {
+	ape_skip* s = ape_skips;
+	if (!s) {
+		/* no seize handled the reversal */
+		printf("unhandled reversal of ");
+		switch (v.kind) {
+		case 0: printf("VOID\n"); break;
+		case 2: printf("%s\n", v.i ? "True" : "False"); break;
+		case 3: printf("%s\n", v.s); break;
+		case 4: printf("%d\n", (int)v.d); break;
+		default: printf("%d\n", v.i);
+		}
+		exit(1);
+	}
+	ape_reversing = 1;
+	while (!s->guard && ape_log_len > s->mark) {
+		ape_undo u = ape_log[--ape_log_len];
+		if (u.compensate) {
+			u.compensate(u.saved);
+		} else if (u.addr) {
+			memcpy(u.addr, u.saved, u.size);
+		}
+		free(u.saved);
+	}
+	ape_reversing = 0;
+	ape_skips = s->prev;
+	s->reversed = v;
+	__builtin_longjmp(s->env, 1);
+}
+
+/* a function with skips returns, so the locals it saved can no longer be restored */
+void ape_leave(int mark) {
+	for (int i = mark; i < ape_log_len; i++) {
+		if (ape_log[i].local) {
+			ape_log[i].addr = 0;
+		}
+	}
+}
+`
+
+// valueKinds are the ape_value kinds and the fields that hold them for each type
+var valueKinds = map[types.Type]struct {
+	kind  int
+	field string
+	box   string
+}{
+	types.Int:    {1, "i", "ape_int"},
+	types.Bool:   {2, "i", "ape_bool"},
+	types.String: {3, "s", "ape_str"},
+	types.Float:  {4, "d", "ape_rational"},
+	types.Double: {4, "d", "ape_rational"},
+}
+
+func (cg *codegen) valueKind(t types.Type) (int, string, string) {
+	if k, ok := valueKinds[t]; ok {
+		return k.kind, k.field, k.box
+	}
+	panic("c backend: values of type " + t.String() + " cannot be reversed or saved")
+}
+
+// value boxes an expression into an ape_value
+func (cg *codegen) value(expr ast.Expression) {
+	if expr == nil {
+		cg.write("ape_void()")
+		return
+	}
+	_, _, box := cg.valueKind(cg.TypeOf(expr))
+	cg.write(box + "(")
+	cg.expr(expr)
+	cg.write(")")
+}
+
+// id returns a name for generated code that is unique within the program
+func (cg *codegen) id(prefix string) string {
+	cg.ids++
+	return fmt.Sprintf("%v_%v", prefix, cg.ids)
+}
+
+// frame is an ape_skip the generated code is in, and the always clause that runs when it is left
+type frame struct {
+	name   string
+	always *ast.BlockStmt
+}
+
+func (cg *codegen) skip(s *ast.SkipStmt) {
+	if len(s.Retries) > 0 {
+		panic("c backend: retry is not supported")
+	}
+	name := cg.id("ape_skip")
+	var guard, propagate string
+	if s.Always != nil {
+		guard, propagate = cg.id("ape_guard"), cg.id("ape_propagate")
+	}
+	cg.write("{\n")
+	cg.indented(func() {
+		cg.sil(fmt.Sprintf("ape_skip %v;\n", name))
+		if s.Always != nil {
+			cg.sil(fmt.Sprintf("ape_skip %v;\n", guard))
+			cg.sil(fmt.Sprintf("volatile int %v = 0;\n", propagate))
+		}
+		cg.sil(fmt.Sprintf("ape_skip_begin(&%v);\n", name))
+		cg.sil(fmt.Sprintf("if (__builtin_setjmp(%v.env) == 0) {\n", name))
+		cg.indented(func() {
+			cg.within(frame{name, s.Always}, func() { cg.stmt(s.Body) })
+			cg.sil(fmt.Sprintf("ape_skip_end(&%v);\n", name))
+		})
+		cg.sil("} else {\n")
+		cg.indented(func() {
+			cg.sil(fmt.Sprintf("ape_value ape_reversed = %v.reversed;\n", name))
+			if s.Always == nil {
+				cg.seizes(s)
+				return
+			}
+			// the always clause runs before a reversal leaves the seizes
+			cg.sil(fmt.Sprintf("ape_guard_begin(&%v);\n", guard))
+			cg.sil(fmt.Sprintf("if (__builtin_setjmp(%v.env) == 0) {\n", guard))
+			cg.indented(func() {
+				cg.within(frame{guard, s.Always}, func() { cg.seizes(s) })
+				cg.sil(fmt.Sprintf("ape_skip_end(&%v);\n", guard))
+			})
+			cg.sil("} else {\n")
+			cg.indented(func() {
+				cg.sil(fmt.Sprintf("%v = 1;\n", propagate))
+			})
+			cg.sil("}\n")
+		})
+		cg.sil("}\n")
+		if s.Always != nil {
+			cg.always(s.Always)
+			cg.sil(fmt.Sprintf("if (%v) {\n", propagate))
+			cg.indented(func() {
+				cg.sil(fmt.Sprintf("ape_reverse(%v.reversed);\n", guard))
+			})
+			cg.sil("}\n")
+		}
+	})
+	cg.sil("}")
+}
+
+// within generates code inside a frame, where breaking out of a loop cannot leave the frame
+func (cg *codegen) within(f frame, gen func()) {
+	skips, loops := cg.skips, cg.loops
+	cg.skips, cg.loops = append(cg.skips, f), 0
+	gen()
+	cg.skips, cg.loops = skips, loops
+}
+
+// seizes picks the seize that handles ape_reversed, reversing the enclosing skip if none does
+func (cg *codegen) seizes(s *ast.SkipStmt) {
+	cg.indent()
+	for _, seize := range s.Seizes {
+		cg.write("if (")
+		cg.seizeCond(seize)
+		cg.write(") {\n")
+		cg.indented(func() {
+			if seize.Name != nil {
+				t := cg.seizeType(seize)
+				_, field, _ := cg.valueKind(t)
+				cg.sil(fmt.Sprintf("%v %v = ape_reversed.%v;\n", cg.typstr(t), seize.Name.Ident.Lexeme, field))
+			}
+			cg.stmt(seize.Body)
+		})
+		cg.sil("} else ")
+	}
+	// no seize matched, so the enclosing skip is reversed with the same value
+	cg.write("{\n")
+	cg.indented(func() {
+		cg.sil("ape_reverse(ape_reversed);\n")
+	})
+	cg.sil("}\n")
+}
+
+// always generates an always clause in a block of its own
+func (cg *codegen) always(always *ast.BlockStmt) {
+	cg.sil("{\n")
+	cg.indented(func() {
+		cg.stmt(always)
+	})
+	cg.sil("}\n")
+}
+
+func (cg *codegen) seizeCond(seize *ast.SeizeStmt) {
+	switch {
+	case seize.Name != nil:
+		kind, _, _ := cg.valueKind(cg.seizeType(seize))
+		cg.write(fmt.Sprintf("ape_reversed.kind == %v", kind))
+	case seize.Expr != nil:
+		cg.write("ape_equals(ape_reversed, ")
+		cg.value(seize.Expr)
+		cg.write(")")
+	default:
+		cg.write("1")
+	}
+}
+
+func (cg *codegen) seizeType(seize *ast.SeizeStmt) types.Type {
+	t := types.LookupPrimitive(seize.Type.Name)
+	if _, ok := valueKinds[t]; ok && !seize.Type.List {
+		return t
+	}
+	panic("c backend: cannot seize values of type " + seize.Type.Name)
+}
+
+func (cg *codegen) reverse(s *ast.ReverseStmt) {
+	if s.To != nil {
+		panic("c backend: reverse to a savepoint is not supported")
+	}
+	cg.write("ape_reverse(")
+	cg.value(s.Expr)
+	cg.write(")")
+}
+
+// compensation registers the @undo annotation of a call, whose arguments are saved
+// now and passed to a function generated to make the call once the skip reverses
+func (cg *codegen) compensation(undo ast.Statement) {
+	stmt, ok := undo.(*ast.ExprStmt)
+	if !ok {
+		panic("c backend: @undo must be a function call")
+	}
+	call, ok := stmt.Expr.(*ast.CallExpr)
+	if !ok {
+		panic("c backend: @undo must be a function call")
+	}
+	callee, ok := call.Callee.(*ast.IdentExpr)
+	if !ok {
+		panic("c backend: @undo must call a function by name")
+	}
+
+	name := cg.id("ape_undo")
+	args := make([]string, 0, len(call.Args))
+	cg.write(fmt.Sprintf("{ ape_value* ape_args = malloc(sizeof(ape_value) * %v); ", len(call.Args)))
+	for i, arg := range call.Args {
+		cg.write(fmt.Sprintf("ape_args[%v] = ", i))
+		cg.value(arg)
+		cg.write("; ")
+		_, field, _ := cg.valueKind(cg.TypeOf(arg))
+		args = append(args, fmt.Sprintf("args[%v].%v", i, field))
+	}
+	cg.write(fmt.Sprintf("void %v(void*); ape_on_reverse(%v, ape_args); }", name, name))
+
+	cg.thunks.WriteString(fmt.Sprintf("\nvoid %v(void* p) {\n\tape_value* args = p;\n\t%v(%v);\n}\n", name, callee.Ident.Lexeme, strings.Join(args, ", ")))
+}
+
+// mentionsIt reports whether an @undo annotation uses the value of the call it annotates
+func mentionsIt(undo ast.Statement) bool {
+	stmt, ok := undo.(*ast.ExprStmt)
+	return ok && ast.Mentions(stmt.Expr, "it")
+}
+
+// save snapshots a variable before it is assigned, if a reversal would have to restore
+// it: globals always, and locals that are assigned inside a skip of their own function
+func (cg *codegen) save(lhs ast.Expression) {
+	ident, ok := lhs.(*ast.IdentExpr)
+	if !ok {
+		return
+	}
+	name := ident.Ident.Lexeme
+	switch {
+	case cg.locals[name] && len(cg.skips) > 0:
+		cg.write(fmt.Sprintf("ape_save(&%v, sizeof(%v), 1), ", name, name))
+	case !cg.locals[name] && cg.globals[name]:
+		cg.write(fmt.Sprintf("ape_save(&%v, sizeof(%v), 0), ", name, name))
+	}
+}
+
+// containsSkip reports whether a function body has its own skip blocks
+func containsSkip(stmt ast.Statement) bool {
+	switch s := stmt.(type) {
+	case *ast.SkipStmt:
+		return true
+	case *ast.BlockStmt:
+		for _, stmt := range s.Content {
+			if containsSkip(stmt) {
+				return true
+			}
+		}
+	case *ast.IfStmt:
+		found := containsSkip(s.If.Body)
+		for _, elif := range s.Elifs {
+			found = found || containsSkip(elif.Body)
+		}
+		return found || (s.Else != nil && containsSkip(s.Else))
+	case *ast.ForStmt:
+		return containsSkip(s.Body)
+	case *ast.SwitchStmt:
+		for _, c := range s.Cases {
+			if containsSkip(c.Body) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// declareLocals adds the names of the variables declared in a statement to locals
+func declareLocals(stmt ast.Statement, locals map[string]bool) {
+	switch s := stmt.(type) {
+	case *ast.TypedDeclStmt:
+		locals[s.Decl.Ident.Lexeme] = true
+	case *ast.BlockStmt:
+		for _, stmt := range s.Content {
+			declareLocals(stmt, locals)
+		}
+	case *ast.IfStmt:
+		declareLocals(s.If.Body, locals)
+		for _, elif := range s.Elifs {
+			declareLocals(elif.Body, locals)
+		}
+		if s.Else != nil {
+			declareLocals(s.Else, locals)
+		}
+	case *ast.ForStmt:
+		if decl, ok := s.Init.(*ast.VarDecl); ok {
+			locals[decl.Ident.Lexeme] = true
+		}
+		declareLocals(s.Body, locals)
+	case *ast.SwitchStmt:
+		for _, c := range s.Cases {
+			declareLocals(c.Body, locals)
+		}
+	case *ast.SkipStmt:
+		declareLocals(s.Body, locals)
+		for _, seize := range s.Seizes {
+			if seize.Name != nil {
+				locals[seize.Name.Ident.Lexeme] = true
+			}
+			declareLocals(seize.Body, locals)
+		}
+		if s.Always != nil {
+			declareLocals(s.Always, locals)
+		}
+	}
+}
@@ -9,8 +9,13 @@ import "github.com/pcen/ape/ape/types"
 func (cg *codegen) typstr(typ types.Type) string {
 	switch t := typ.(type) {
 	case types.Primitive:
-		if t.Is(types.String) {
+		switch {
+		case t.Is(types.String):
 			return "char*"
+		case t.Is(types.Bool):
+			return "int"
+		case t.Is(types.Void):
+			return "void"
 		}
 		return t.String()
 	case types.Named:
@@ -0,0 +1,177 @@
+package interpreter
+
+import (
+	"github.com/pcen/ape/ape/ast"
+)
+
+/*
+*
+The run of an atomic skip block. Tasks only take turns while one of them waits, so a
+transaction can only see another task's changes if it waits part way through, on a
+channel, a join or a request. It records the version of each global it reads or writes
+the first time it does, and owns the globals it writes until it ends, so no other
+transaction touches what it has changed but not committed. It commits when none of the
+globals it touched have a newer version by the end of its body, and is otherwise
+reversed with its bread crumbs and run again. A transaction inside a skip block keeps
+the globals it wrote until the outermost skip block finishes, since that can still
+reverse it. Changes are tracked by the name of the global they are made through, so
+changing a list or map a function was passed is not seen.
+*/
+type transaction struct {
+	skip   *ast.SkipStmt
+	seen   map[string]int  // version of each global touched, when it was first touched
+	writes map[string]bool // globals owned by the transaction
+	done   chan struct{}   // closed once the transaction has committed or been reversed
+}
+
+/** Raised by a transaction that touched a global another one changed, to run it again */
+type conflict struct {
+	txn   *transaction
+	owner *transaction // transaction to wait for before running again, nil if it already ended
+}
+
+func (tg *taskGroup) begin(skip *ast.SkipStmt) *transaction {
+	if tg.versions == nil {
+		tg.versions = make(map[string]int)
+		tg.owners = make(map[string]*transaction)
+	}
+	return &transaction{skip: skip, seen: make(map[string]int), writes: make(map[string]bool), done: make(chan struct{})}
+}
+
+/** Gives up the globals the transaction owns, making what it wrote to them a new version if it committed */
+func (tg *taskGroup) end(txn *transaction, committed bool) {
+	for name := range txn.writes {
+		if committed {
+			tg.versions[name]++
+		}
+		// a later transaction of the same task can own it now
+		if tg.owners[name] == txn {
+			delete(tg.owners, name)
+		}
+	}
+	close(txn.done)
+}
+
+/** Ends the transactions the task holds from first on, once the skip block holding them finishes or is reversed */
+func (twi *TWI) release(first int, committed bool) {
+	for _, txn := range twi.held[first:] {
+		twi.tasks.end(txn, committed)
+	}
+	twi.held = twi.held[:first]
+}
+
+/** True if the transaction committed inside a skip block of this task that is still running */
+func (twi *TWI) holds(txn *transaction) bool {
+	for _, held := range twi.held {
+		if held == txn {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+*
+Records a read or write of a variable by the running transaction, if it is a global.
+Writes made outside of any transaction wait for the transaction that owns the global,
+then commit at once
+*/
+func (twi *TWI) touch(name string, write bool) {
+	tg := twi.tasks
+	if tg.versions == nil || twi.reversing || twi.CurrentScope.GetScope(name) != twi.GlobalScope {
+		// no transaction has run yet, or this is not a global
+		return
+	}
+	if twi.txn == nil {
+		if !write {
+			return
+		}
+		for owner := tg.owners[name]; owner != nil && !twi.holds(owner); owner = tg.owners[name] {
+			twi.blocking(func() { <-owner.done })
+		}
+		tg.versions[name]++
+		return
+	}
+
+	if owner := tg.owners[name]; owner != nil && owner != twi.txn && !twi.holds(owner) {
+		panic(conflict{txn: twi.txn, owner: owner})
+	}
+	if _, ok := twi.txn.seen[name]; !ok {
+		twi.txn.seen[name] = tg.versions[name]
+	}
+	if write {
+		twi.txn.writes[name] = true
+		tg.owners[name] = twi.txn
+	}
+}
+
+/** The global an assignment or method call changes, if it changes a variable rather than a value it was given */
+func rootName(expr ast.Expression) (string, bool) {
+	switch e := expr.(type) {
+	case *ast.IdentExpr:
+		return e.Ident.Lexeme, true
+	case *ast.IndexExpr:
+		return rootName(e.Expr)
+	case *ast.DotExpr:
+		return rootName(e.Expr)
+	}
+	return "", false
+}
+
+/** Records a write to the variable expr is part of */
+func (twi *TWI) touchRoot(expr ast.Expression) {
+	if name, ok := rootName(expr); ok {
+		twi.touch(name, true)
+	}
+}
+
+/** Raises a conflict if the skip is the body of the running transaction and it cannot commit */
+func (twi *TWI) validate(skip *ast.SkipStmt) *conflict {
+	if twi.txn == nil || twi.txn.skip != skip {
+		return nil
+	}
+	for name, version := range twi.txn.seen {
+		if twi.tasks.versions[name] != version {
+			return &conflict{txn: twi.txn}
+		}
+	}
+	return nil
+}
+
+/** Runs the body of an atomic skip as a transaction until it does not conflict with another */
+func (twi *TWI) attemptAtomic(stmt *ast.SkipStmt, scope *Scope) (caught *ast.SeizeStmt, reversed value) {
+	for {
+		conflicted, caught, reversed := twi.transact(stmt, scope)
+		if !conflicted {
+			return caught, reversed
+		}
+	}
+}
+
+func (twi *TWI) transact(stmt *ast.SkipStmt, scope *Scope) (conflicted bool, caught *ast.SeizeStmt, reversed value) {
+	txn := twi.tasks.begin(stmt)
+	twi.txn = txn
+	defer func() {
+		twi.txn = nil
+		panic_val := recover()
+		_, returned := panic_val.(ReturnHolder)
+		if committed := returned || panic_val == nil && caught == nil; committed && len(twi.skips) > 0 {
+			// the skip block the transaction is in can still reverse what it wrote
+			twi.held = append(twi.held, txn)
+		} else {
+			twi.tasks.end(txn, committed)
+		}
+		if panic_val != nil {
+			c, ok := panic_val.(conflict)
+			if !ok || c.txn != txn {
+				panic(panic_val)
+			}
+			conflicted = true
+			if c.owner != nil {
+				twi.blocking(func() { <-c.owner.done })
+			}
+		}
+	}()
+	caught, reversed = twi.attemptSkip(stmt, scope)
+	return false, caught, reversed
+}
@@ -0,0 +1,215 @@
+package interpreter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pcen/ape/ape/ast"
+	"github.com/pcen/ape/ape/token"
+)
+
+/** A statement the debugger stopped at, before it runs. The last step has no statement once main has returned */
+type Step struct {
+	Stmt    ast.Statement
+	Pos     token.Position
+	Func    string // function the statement is in
+	Scope   *Scope // scope the statement runs in, its chain ends at the global scope
+	changes int    // number of changes made to the program state before the statement
+}
+
+/*
+*
+Runs main one statement at a time and travels back through the statements it already
+ran. Every change the program makes is logged as a bread crumb that undoes it, including
+the bread crumbs a skip block reverses, so stepping back reverses the log and stepping
+forward again redoes it, until the program has to run further. Changes outside of the
+interpreter, such as files written by natives or @undo compensations, are not replayed.
+*/
+type Debugger struct {
+	twi     *TWI
+	steps   []Step
+	at      int           // index of the step the program is stopped at
+	changes []*BreadCrumb // changes made up to the current step, oldest first
+	undone  []*BreadCrumb // changes undone by stepping back, the next one to redo last
+	stopped chan bool     // true when the program reaches a statement, false when it ends
+	resume  chan struct{}
+	done    bool
+	Err     error // the error main stopped with, once it has finished
+}
+
+/** Attaches a debugger to an interpreter that has its declarations loaded */
+func NewDebugger(twi *TWI) *Debugger {
+	d := &Debugger{
+		twi:     twi,
+		stopped: make(chan bool),
+		resume:  make(chan struct{}),
+	}
+	twi.debugger = d
+	return d
+}
+
+/** Runs main until its first statement, returning false if it has none */
+func (d *Debugger) Start() bool {
+	go func() {
+		d.Err = d.twi.RunMain()
+		d.stopped <- false
+	}()
+	d.wait()
+	return !d.done
+}
+
+/** Waits for the program to reach its next statement or to finish */
+func (d *Debugger) wait() {
+	if !<-d.stopped {
+		d.done = true
+		d.steps = append(d.steps, Step{Scope: d.twi.GlobalScope, changes: len(d.changes)})
+	}
+}
+
+/** The statement the program is stopped at */
+func (d *Debugger) Current() Step {
+	return d.steps[d.at]
+}
+
+/** Whether main has returned */
+func (d *Debugger) Finished() bool {
+	return d.done && d.at == len(d.steps)-1
+}
+
+/** Runs the current statement, returning false if the program had already finished */
+func (d *Debugger) Forward() bool {
+	if d.at < len(d.steps)-1 {
+		// redo what stepping back undid
+		d.at++
+		for len(d.changes) < d.steps[d.at].changes {
+			d.replay(&d.undone, &d.changes)
+		}
+		return true
+	}
+	if d.done {
+		return false
+	}
+	d.resume <- struct{}{}
+	d.wait()
+	d.at++
+	return true
+}
+
+/** Goes back to before the previous statement ran, returning false at the first statement */
+func (d *Debugger) Back() bool {
+	if d.at == 0 {
+		return false
+	}
+	d.at--
+	for len(d.changes) > d.steps[d.at].changes {
+		d.replay(&d.changes, &d.undone)
+	}
+	return true
+}
+
+/** Moves forward until a line with a breakpoint, returning false if the program finishes first */
+func (d *Debugger) Continue(breakpoints map[uint]bool) bool {
+	for d.Forward() && !d.Finished() {
+		if breakpoints[d.Current().Pos.Line] {
+			return true
+		}
+	}
+	return false
+}
+
+/** Moves back until a line with a breakpoint, returning false if the first statement is reached first */
+func (d *Debugger) ReverseContinue(breakpoints map[uint]bool) bool {
+	for d.Back() {
+		if breakpoints[d.Current().Pos.Line] {
+			return true
+		}
+	}
+	return false
+}
+
+/** Undoes the most recent change in from, keeping what undoes that in to */
+func (d *Debugger) replay(from, to *[]*BreadCrumb) {
+	last := len(*from) - 1
+	bc := (*from)[last]
+	*from = (*from)[:last]
+	inverse := bc.inverse()
+	d.twi.reversing = true
+	bc.Reverse(d.twi)
+	d.twi.reversing = false
+	*to = append(*to, inverse)
+}
+
+/** Called before each statement runs forwards, blocking until the debugger resumes the program */
+func (d *Debugger) reached(stmt ast.Statement) {
+	if _, ok := stmt.(*ast.BlockStmt); ok || d.twi.reversing {
+		return
+	}
+	d.steps = append(d.steps, Step{Stmt: stmt, Pos: ast.Pos(stmt), Func: d.twi.function(), Scope: d.twi.CurrentScope, changes: len(d.changes)})
+	d.stopped <- true
+	<-d.resume
+}
+
+/** Logs a bread crumb left by the program */
+func (d *Debugger) pushed(bc *BreadCrumb) {
+	switch bc.PrevVal.(type) {
+	case rev_call:
+		// stands in for the bread crumbs of the call, which were already logged
+		return
+	}
+	if bc.SkipMarker == nil && bc.inverse() != nil {
+		d.changes = append(d.changes, bc)
+	}
+}
+
+/** Logs a bread crumb the program is about to reverse, by what undoes the reversal */
+func (d *Debugger) reversing(bc *BreadCrumb) {
+	if inverse := bc.inverse(); inverse != nil {
+		d.changes = append(d.changes, inverse)
+	}
+}
+
+/** The bread crumb that undoes reversing bc, which captures the state bc is about to restore */
+func (bc *BreadCrumb) inverse() *BreadCrumb {
+	inverse := &BreadCrumb{Scope: bc.Scope, Name: bc.Name}
+	switch t := bc.PrevVal.(type) {
+	case list_push:
+		inverse.PrevVal = list_pop{List: t.List, Value: (*t.List.Data)[len(*t.List.Data)-1]}
+	case list_pop:
+		inverse.PrevVal = list_push{List: t.List}
+	case list_set:
+		inverse.PrevVal = list_set{List: t.List, Index: t.Index, Value: (*t.List.Data)[t.Index]}
+	case map_entry:
+		prev, existed := t.Map.Data[t.Key]
+		inverse.PrevVal = map_entry{Map: t.Map, Key: t.Key, Value: prev, Existed: existed}
+	case rev_call:
+		inverse.PrevVal = rev_call{Call: ast.Invert(t.Call).(*ast.CallStmt)}
+	case declaration:
+		prev, existed := bc.Scope.Values[bc.Name]
+		inverse.PrevVal = declaration{Value: prev, Existed: existed}
+	case value:
+		inverse.PrevVal = bc.Scope.Get(bc.Name)
+	default:
+		// native effects and @undo compensations change things outside of the interpreter
+		return nil
+	}
+	return inverse
+}
+
+/** Lists the variables of a scope, leaving out functions and classes */
+func (s *Scope) String() string {
+	names := make([]string, 0, len(s.Values))
+	for name, val := range s.Values {
+		switch val.(type) {
+		case val_func, val_native_func, val_class:
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	vars := make([]string, 0, len(names))
+	for _, name := range names {
+		vars = append(vars, fmt.Sprintf("%v = %v", name, s.Values[name].ToString()))
+	}
+	return strings.Join(vars, ", ")
+}
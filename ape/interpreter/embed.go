@@ -0,0 +1,426 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pcen/ape/ape"
+)
+
+/*
+*
+An interpreter for Go programs to embed. The host registers natives of its own, loads
+scripts from source and calls their functions with Go values. Nothing a script does
+panics or exits the host, whatever goes wrong comes back as an error: a RuntimeError, an
+*UnhandledReversal for a reverse no skip block seized, or an error describing the source
+or values that could not be used.
+*/
+type Engine struct {
+	twi *TWI
+}
+
+func NewEngine() *Engine {
+	return &Engine{twi: NewTWI()}
+}
+
+/** Sets where the scripts print to, which is os.Stdout unless set */
+func (e *Engine) SetOutput(w io.Writer) {
+	e.twi.tasks.lock.Lock()
+	defer e.twi.tasks.lock.Unlock()
+	e.twi.Stdout = w
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+/*
+*
+Makes fn callable from scripts by name. fn is a Go function taking and returning ints,
+floats, strings, bools, and slices and maps of them. It may return one value, and an
+error last, which fails the call with a runtime error that reverses the skip block it is
+in. inverse is nil or a function taking the same parameters as fn, and is called to undo a
+call of fn made inside a skip block that is reversed. It may return an error, which fails
+the reversal the same as any other compensation that fails
+*/
+func (e *Engine) Register(name string, fn interface{}, inverse interface{}) error {
+	fv := reflect.ValueOf(fn)
+	if err := checkNative(name, fv, true); err != nil {
+		return err
+	}
+	params := make([]string, fv.Type().NumIn())
+	for i := range params {
+		params[i] = strconv.Itoa(i)
+	}
+	// the inverse is a native scripts cannot name, which journals can still call when recovering
+	undo := val_native_func{Name: "undo " + name, Params: params}
+	native := val_native_func{
+		Name:   name,
+		Params: params,
+		Fn: func(twi *TWI, scope *Scope) {
+			result := callNative(fv, scope)
+			// a call that failed has nothing to undo, so it is only recorded once it succeeds
+			if undo.Fn != nil && len(twi.skips) > 0 && !twi.reversing {
+				args := make([]value, len(params))
+				for i, param := range params {
+					args[i] = scope.Get(param)
+				}
+				twi.leaveBreadCrumb(native_effect{Undo: native_call{Fn: undo, Args: args}})
+			}
+			if result != nil {
+				panic(ReturnHolder{Value: result})
+			}
+		},
+	}
+	if inverse != nil {
+		iv := reflect.ValueOf(inverse)
+		if err := checkNative(name, iv, false); err != nil {
+			return fmt.Errorf("inverse of %v", err)
+		}
+		if iv.Type().NumIn() != fv.Type().NumIn() {
+			return fmt.Errorf("inverse of %v takes %v parameters, not %v", name, iv.Type().NumIn(), fv.Type().NumIn())
+		}
+		for i := 0; i < iv.Type().NumIn(); i++ {
+			if iv.Type().In(i) != fv.Type().In(i) {
+				return fmt.Errorf("parameter %v of the inverse of %v is a %v, not a %v", i, name, iv.Type().In(i), fv.Type().In(i))
+			}
+		}
+		undo.Fn = func(twi *TWI, scope *Scope) {
+			callNative(iv, scope)
+		}
+	}
+
+	e.twi.tasks.lock.Lock()
+	defer e.twi.tasks.lock.Unlock()
+	if _, ok := e.twi.GlobalScope.Values[name]; ok {
+		return fmt.Errorf("%v is already defined", name)
+	}
+	e.twi.GlobalScope.Define(name, native)
+	e.twi.natives[name] = native
+	if undo.Fn != nil {
+		e.twi.natives[undo.Name] = undo
+	}
+	return nil
+}
+
+/** Checks that a Go function can be called from scripts, and that a forward one returns at most one value before its error */
+func checkNative(name string, fn reflect.Value, forward bool) error {
+	if fn.Kind() != reflect.Func || fn.IsNil() {
+		return fmt.Errorf("%v is not a function", name)
+	}
+	t := fn.Type()
+	if t.IsVariadic() {
+		return fmt.Errorf("%v is variadic", name)
+	}
+	for i := 0; i < t.NumIn(); i++ {
+		if !convertible(t.In(i)) {
+			return fmt.Errorf("parameter %v of %v is a %v, which scripts have no values of", i, name, t.In(i))
+		}
+	}
+	results := t.NumOut()
+	if results > 0 && t.Out(results-1) == errorType {
+		results--
+	}
+	switch {
+	case results > 1 || (results == 1 && !forward):
+		return fmt.Errorf("%v returns too many values", name)
+	case results == 1 && !convertible(t.Out(0)):
+		return fmt.Errorf("%v returns a %v, which scripts have no values of", name, t.Out(0))
+	}
+	return nil
+}
+
+/** Calls a registered Go function with the arguments in scope, returning its result if it has one */
+func callNative(fn reflect.Value, scope *Scope) value {
+	t := fn.Type()
+	args := make([]reflect.Value, t.NumIn())
+	for i := range args {
+		arg, err := toGo(scope.Get(strconv.Itoa(i)), t.In(i))
+		if err != nil {
+			panic(fmt.Sprintf("argument %v: %v", i, err))
+		}
+		args[i] = arg
+	}
+	out := fn.Call(args)
+	if len(out) > 0 && t.Out(len(out)-1) == errorType {
+		if err := out[len(out)-1]; !err.IsNil() {
+			panic(err.Interface().(error))
+		}
+		out = out[:len(out)-1]
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	result, err := toApe(out[0])
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+/** Loads a script, defining its functions and globals. Scripts loaded later see those of earlier ones */
+func (e *Engine) Load(source string) (err error) {
+	defer e.catch(&err)
+	parser := ape.NewParser(ape.NewLexer().LexString(source))
+	decls := parser.Program()
+	if errs, ok := parser.Errors(); ok {
+		msgs := make([]string, 0, len(errs))
+		for _, perr := range errs {
+			msgs = append(msgs, perr.String())
+		}
+		return errors.New(strings.Join(msgs, "\n"))
+	}
+	for _, decl := range decls {
+		e.twi.Interpret(decl)
+	}
+	return nil
+}
+
+/*
+*
+Calls a function of a loaded script with Go arguments, returning its result as a Go
+value: an int, float64, string, bool, []interface{}, map[interface{}]interface{}, or nil
+for a function that returns nothing. Tasks the call spawns finish before it returns
+*/
+func (e *Engine) Call(name string, args ...interface{}) (result interface{}, err error) {
+	val, err := e.call(name, args)
+	if err != nil {
+		return nil, err
+	}
+	if result, err = fromApe(val); err != nil {
+		return nil, fmt.Errorf("result of %v: %v", name, err)
+	}
+	return result, nil
+}
+
+/** Calls a function of a loaded script, returning its result as a T */
+func CallAs[T any](e *Engine, name string, args ...interface{}) (result T, err error) {
+	val, err := e.call(name, args)
+	if err != nil {
+		return result, err
+	}
+	converted, err := toGo(val, reflect.TypeOf(&result).Elem())
+	if err != nil {
+		return result, fmt.Errorf("result of %v: %v", name, err)
+	}
+	return converted.Interface().(T), nil
+}
+
+func (e *Engine) call(name string, args []interface{}) (result value, err error) {
+	defer e.catch(&err)
+	vals := make([]value, len(args))
+	for i, arg := range args {
+		if vals[i], err = toApe(reflect.ValueOf(arg)); err != nil {
+			return nil, fmt.Errorf("argument %v of %v: %v", i, name, err)
+		}
+	}
+
+	twi := e.twi
+	twi.tasks.lock.Lock()
+	fn, ok := twi.GlobalScope.Values[name]
+	if !ok {
+		twi.tasks.lock.Unlock()
+		return nil, fmt.Errorf("%v is not defined", name)
+	}
+	defer twi.tasks.wg.Wait()
+	defer twi.tasks.lock.Unlock()
+	return twi.run(func() value { return twi.call(fn, vals) })
+}
+
+/** Turns a panic that got out of the interpreter into an error for the host */
+func (e *Engine) catch(err *error) {
+	panic_val := recover()
+	if panic_val == nil {
+		return
+	}
+	if holder, ok := panic_val.(ReverseHolder); ok {
+		*err = holder.Trace
+	} else if rerr, ok := e.twi.runtimeError(panic_val); ok {
+		*err = rerr
+	} else {
+		*err = fmt.Errorf("%v", panic_val)
+	}
+}
+
+/** True for the Go types that values of scripts convert to and from */
+func convertible(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.String, reflect.Bool:
+		return true
+	case reflect.Slice:
+		return convertible(t.Elem())
+	case reflect.Map:
+		return convertible(t.Key()) && convertible(t.Elem())
+	case reflect.Interface:
+		return t.NumMethod() == 0
+	}
+	return false
+}
+
+/** Converts a Go value to the value of a script */
+func toApe(v reflect.Value) (value, error) {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return val_void{}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return val_int{int(v.Int())}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return val_int{int(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return val_rational{v.Float()}, nil
+	case reflect.String:
+		return val_str{v.String()}, nil
+	case reflect.Bool:
+		return val_bool{v.Bool()}, nil
+	case reflect.Interface:
+		if v.IsNil() {
+			return val_void{}, nil
+		}
+		return toApe(v.Elem())
+	case reflect.Slice, reflect.Array:
+		elements := make([]value, v.Len())
+		for i := range elements {
+			el, err := toApe(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = el
+		}
+		return newList(elements), nil
+	case reflect.Map:
+		m := val_map{Data: make(map[value]value, v.Len())}
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := toApe(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			val, err := toApe(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			m.Data[key] = val
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("scripts have no values of type %v", v.Type())
+}
+
+/** Converts the value of a script to a Go value of type t */
+func toGo(v value, t reflect.Type) (reflect.Value, error) {
+	switch t.Kind() {
+	case reflect.Interface:
+		val, err := fromApe(v)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if val == nil {
+			return reflect.Zero(t), nil
+		}
+		return reflect.ValueOf(val), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if i, ok := v.(val_int); ok {
+			return reflect.ValueOf(i.Value).Convert(t), nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch n := v.(type) {
+		case val_rational:
+			return reflect.ValueOf(n.Value).Convert(t), nil
+		case val_int:
+			return reflect.ValueOf(n.Value).Convert(t), nil
+		}
+	case reflect.String:
+		if s, ok := v.(val_str); ok {
+			return reflect.ValueOf(s.Value).Convert(t), nil
+		}
+	case reflect.Bool:
+		if b, ok := v.(val_bool); ok {
+			return reflect.ValueOf(b.Value).Convert(t), nil
+		}
+	case reflect.Slice:
+		l, ok := v.(val_list)
+		if !ok {
+			break
+		}
+		s := reflect.MakeSlice(t, len(*l.Data), len(*l.Data))
+		for i, el := range *l.Data {
+			converted, err := toGo(el, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			s.Index(i).Set(converted)
+		}
+		return s, nil
+	case reflect.Map:
+		m, ok := v.(val_map)
+		if !ok {
+			break
+		}
+		converted := reflect.MakeMapWithSize(t, len(m.Data))
+		for key, val := range m.Data {
+			k, err := toGo(key, t.Key())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			if !k.Type().Comparable() {
+				return reflect.Value{}, fmt.Errorf("%v cannot be the key of a Go map", key.ToString())
+			}
+			e, err := toGo(val, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			converted.SetMapIndex(k, e)
+		}
+		return converted, nil
+	}
+	return reflect.Value{}, fmt.Errorf("cannot use %v as a %v", v.ToString(), t)
+}
+
+/** Converts the value of a script to the Go value it is closest to */
+func fromApe(v value) (interface{}, error) {
+	switch t := v.(type) {
+	case val_void:
+		return nil, nil
+	case val_int:
+		return t.Value, nil
+	case val_rational:
+		return t.Value, nil
+	case val_str:
+		return t.Value, nil
+	case val_bool:
+		return t.Value, nil
+	case val_list:
+		elements := make([]interface{}, len(*t.Data))
+		for i, el := range *t.Data {
+			converted, err := fromApe(el)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = converted
+		}
+		return elements, nil
+	case val_map:
+		m := make(map[interface{}]interface{}, len(t.Data))
+		for key, val := range t.Data {
+			k, err := fromApe(key)
+			if err != nil {
+				return nil, err
+			}
+			if k != nil && !reflect.TypeOf(k).Comparable() {
+				return nil, fmt.Errorf("%v cannot be the key of a Go map", key.ToString())
+			}
+			e, err := fromApe(val)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = e
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("%v has no Go value", v.ToString())
+}
@@ -0,0 +1,96 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pcen/ape/ape/token"
+)
+
+/** Builtin class of the value a skip block is reversed with when a runtime error happens in it */
+var runtimeErrorClass = val_class{Name: "RuntimeError", Members: []string{"message", "line", "column"}}
+
+/** A failure while running a script, such as dividing by zero or reading a file that does not exist */
+type RuntimeError struct {
+	Pos   token.Position
+	Msg   string
+	Trace *UnhandledReversal // skip blocks the error reversed before ending the program, nil if it was in none
+}
+
+func (e RuntimeError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v: runtime error: %v", e.Pos, e.Msg)
+	if e.Trace != nil {
+		e.Trace.writeTrace(&b)
+	}
+	return b.String()
+}
+
+func (e RuntimeError) value() val_object {
+	return runtimeErrorClass.construct([]value{val_str{e.Msg}, val_int{int(e.Pos.Line)}, val_int{int(e.Pos.Column)}})
+}
+
+/** A skip block an unhandled reversal passed through */
+type TracedSkip struct {
+	Pos  token.Position
+	Func string // function the skip block is in
+}
+
+/*
+*
+A reversal that no seize handled, which ends the program. It is traced from the reverse
+statement through every skip block it reversed, along with the compensations those skip
+blocks ran while reversing
+*/
+type UnhandledReversal struct {
+	Value         string // the reversed value
+	Pos           token.Position
+	Skips         []TracedSkip // innermost first
+	Compensations []string     // in the order they ran
+}
+
+func (e *UnhandledReversal) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v: unhandled reversal of %v", e.Pos, e.Value)
+	e.writeTrace(&b)
+	return b.String()
+}
+
+/** Writes the skip blocks the reversal passed through and the compensations they ran */
+func (e *UnhandledReversal) writeTrace(b *strings.Builder) {
+	for _, skip := range e.Skips {
+		fmt.Fprintf(b, "\n  reversed skip at %v in %v", skip.Pos, skip.Func)
+	}
+	if len(e.Compensations) > 0 {
+		b.WriteString("\n  compensations run:")
+		for _, compensation := range e.Compensations {
+			fmt.Fprintf(b, "\n    %v", compensation)
+		}
+	}
+}
+
+/** Recovers the runtime error a RuntimeError object was made from */
+func runtimeErrorOf(v value) (RuntimeError, bool) {
+	obj, ok := v.(val_object)
+	if !ok || !obj.Class.Equals(runtimeErrorClass) {
+		return RuntimeError{}, false
+	}
+	return RuntimeError{
+		Pos: token.Position{Line: uint(obj.Fields["line"].(val_int).Value), Column: uint(obj.Fields["column"].(val_int).Value)},
+		Msg: obj.Fields["message"].(val_str).Value,
+	}, true
+}
+
+/** Converts anything the interpreter panicked with, other than control flow, into a runtime error */
+func (twi *TWI) runtimeError(panic_val interface{}) (RuntimeError, bool) {
+	switch t := panic_val.(type) {
+	case ReturnHolder, ReverseHolder, RetryHolder, conflict:
+		return RuntimeError{}, false
+	case RuntimeError:
+		return t, true
+	case error:
+		return RuntimeError{Pos: twi.pos, Msg: strings.TrimPrefix(t.Error(), "runtime error: ")}, true
+	default:
+		return RuntimeError{Pos: twi.pos, Msg: fmt.Sprint(t)}, true
+	}
+}
@@ -0,0 +1,79 @@
+package interpreter
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+/*
+*
+Makes a request and returns the body of the response. Responses with an error status are
+runtime errors, so a failed step of a saga reverses the skip block it is in, running the
+compensations of the steps before it. The natives have no inverses of their own, the
+compensating request is declared with @undo, where it is bound to the forward response
+*/
+func (twi *TWI) request(method string, url string, body io.Reader) value {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		panic(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// other tasks run while the request is in flight
+	var resp *http.Response
+	var data []byte
+	twi.blocking(func() {
+		if resp, err = httpClient.Do(req); err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		data, err = io.ReadAll(resp.Body)
+	})
+	if err != nil {
+		panic(err)
+	}
+	if resp.StatusCode >= 400 {
+		panic(fmt.Sprintf("%v %v: %v", method, url, resp.Status))
+	}
+	return val_str{string(data)}
+}
+
+/** Natives making http requests */
+var HTTP_NATIVES = []val_native_func{
+	{
+		Name:   "http_get",
+		Params: []string{"url"},
+		Fn: func(twi *TWI, scope *Scope) {
+			panic(ReturnHolder{Value: twi.request(http.MethodGet, scope.Get("url").(val_str).Value, nil)})
+		},
+	},
+	{
+		Name:   "http_post",
+		Params: []string{"url", "body"},
+		Fn: func(twi *TWI, scope *Scope) {
+			body := strings.NewReader(scope.Get("body").(val_str).Value)
+			panic(ReturnHolder{Value: twi.request(http.MethodPost, scope.Get("url").(val_str).Value, body)})
+		},
+	},
+	{
+		Name:   "http_put",
+		Params: []string{"url", "body"},
+		Fn: func(twi *TWI, scope *Scope) {
+			body := strings.NewReader(scope.Get("body").(val_str).Value)
+			panic(ReturnHolder{Value: twi.request(http.MethodPut, scope.Get("url").(val_str).Value, body)})
+		},
+	},
+	{
+		Name:   "http_delete",
+		Params: []string{"url"},
+		Fn: func(twi *TWI, scope *Scope) {
+			panic(ReturnHolder{Value: twi.request(http.MethodDelete, scope.Get("url").(val_str).Value, nil)})
+		},
+	},
+}
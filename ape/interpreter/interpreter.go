@@ -1,9 +1,12 @@
 package interpreter
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -16,21 +19,21 @@ import (
 var NATIVE_FUNCTIONS = []val_native_func{
 	{
 		Name: "println",
-		Fn: func(scope *Scope) {
+		Fn: func(twi *TWI, scope *Scope) {
 			var sb strings.Builder
 			for i := 0; i < len(scope.Values); i++ {
 				index := strconv.FormatInt(int64(i), 10)
 				val := scope.Values[index]
 				sb.WriteString(val.ToString())
 			}
-			fmt.Println(sb.String())
+			twi.print(sb.String() + "\n")
 		},
 		Variadic: true,
 	},
 	{
 		Name:   "read",
 		Params: []string{"filename"},
-		Fn: func(scope *Scope) {
+		Fn: func(twi *TWI, scope *Scope) {
 			filename := scope.Get("filename").(val_str)
 			bytes, err := os.ReadFile(filename.Value)
 			if err != nil {
@@ -42,7 +45,7 @@ var NATIVE_FUNCTIONS = []val_native_func{
 	{
 		Name:   "write",
 		Params: []string{"filename", "data"},
-		Fn: func(scope *Scope) {
+		Fn: func(twi *TWI, scope *Scope) {
 			filename := scope.Get("filename").(val_str)
 			content := scope.Get("data").(val_str)
 			err := os.WriteFile(filename.Value, []byte(content.Value), os.ModePerm)
@@ -50,27 +53,99 @@ var NATIVE_FUNCTIONS = []val_native_func{
 				panic(err)
 			}
 		},
+		// snapshot the previous contents, or restore that the file did not exist
+		Inverse: func(twi *TWI, scope *Scope) *native_effect {
+			filename := scope.Get("filename").(val_str)
+			effect := &native_effect{Undo: twi.nativeCall("delete", filename)}
+			if prev, err := os.ReadFile(filename.Value); err == nil {
+				effect.Undo = twi.nativeCall("write", filename, val_str{string(prev)})
+			}
+			return effect
+		},
 	},
 	{
 		Name:   "touch",
 		Params: []string{"filename"},
-		Fn: func(scope *Scope) {
+		Fn: func(twi *TWI, scope *Scope) {
 			filename := scope.Get("filename").(val_str)
-			os.Create(filename.Value)
+			file, err := os.Create(filename.Value)
+			if err != nil {
+				panic(err)
+			}
+			file.Close()
+		},
+		// touching a file empties it, so snapshot the previous contents like write
+		Inverse: func(twi *TWI, scope *Scope) *native_effect {
+			filename := scope.Get("filename").(val_str)
+			effect := &native_effect{Undo: twi.nativeCall("delete", filename)}
+			if prev, err := os.ReadFile(filename.Value); err == nil {
+				effect.Undo = twi.nativeCall("write", filename, val_str{string(prev)})
+			}
+			return effect
 		},
 	},
 	{
-		Name:   "delete",
-		Params: []string{"filename"},
-		Fn: func(scope *Scope) {
-			filename := scope.Get("filename").(val_str)
-			os.Remove(filename.Value)
+		// delete(filename) removes a file, delete(m, key) removes a key from a map
+		Name: "delete",
+		Fn: func(twi *TWI, scope *Scope) {
+			switch target := scope.Get("0").(type) {
+			case val_map:
+				twi.deleteKey(target, scope.Get("1"))
+			case val_str:
+				os.Remove(target.Value)
+			default:
+				panic(fmt.Sprintf("Cannot delete %s", target.ToString()))
+			}
+		},
+		// files are moved aside, and only removed once the skip block can no longer reverse
+		Inverse: func(twi *TWI, scope *Scope) *native_effect {
+			filename, ok := scope.Get("0").(val_str)
+			if !ok {
+				return nil // map keys leave their own bread crumb
+			}
+			if _, err := os.Stat(filename.Value); err != nil {
+				return nil
+			}
+			// pick a free name next to the file to move it to, without creating anything there
+			// that the undo could restore if the move never happens
+			dir, base := filepath.Split(filename.Value)
+			var aside val_str
+			for i := 0; ; i++ {
+				aside = val_str{filepath.Join(dir, fmt.Sprintf(".%v.deleted-%v", base, i))}
+				if _, err := os.Lstat(aside.Value); os.IsNotExist(err) {
+					break
+				}
+			}
+			do, commit := twi.nativeCall("move", filename, aside), twi.nativeCall("delete", aside)
+			return &native_effect{
+				Do:     &do,
+				Undo:   twi.nativeCall("unmove", filename, aside, val_bool{false}, val_str{""}),
+				Commit: &commit,
+			}
+		},
+		Variadic: true,
+	},
+	{
+		Name:   "move",
+		Params: []string{"from", "to"},
+		Fn: func(twi *TWI, scope *Scope) {
+			from := scope.Get("from").(val_str)
+			to := scope.Get("to").(val_str)
+			if err := os.Rename(from.Value, to.Value); err != nil {
+				panic(err)
+			}
+		},
+		// snapshot the file the move replaces, if there is one
+		Inverse: func(twi *TWI, scope *Scope) *native_effect {
+			from, to := scope.Get("from").(val_str), scope.Get("to").(val_str)
+			prev, err := os.ReadFile(to.Value)
+			return &native_effect{Undo: twi.nativeCall("unmove", from, to, val_bool{err == nil}, val_str{string(prev)})}
 		},
 	},
 	{
 		Name:   "shell",
 		Params: []string{"cmd"},
-		Fn: func(scope *Scope) {
+		Fn: func(twi *TWI, scope *Scope) {
 			cmdstr := scope.Get("cmd").(val_str).Value
 			// split := strings.Split(cmdstr, " ")
 			// name := split[0]
@@ -84,7 +159,35 @@ var NATIVE_FUNCTIONS = []val_native_func{
 			// if err != nil {
 			// 	panic(err)
 			// }
-			fmt.Printf("%s", b)
+			twi.print(string(b))
+		},
+	},
+}
+
+/*
+*
+Natives only the bread crumbs of the file natives call, which scripts cannot see. Bread
+crumbs are journaled before the call they reverse, so after a crash they can be run for a
+call that never happened
+*/
+var fileInverses = []val_native_func{
+	{
+		// moves the file back and restores the one it replaced, unless it was never moved
+		Name:   "unmove",
+		Params: []string{"from", "to", "replaced", "prev"},
+		Fn: func(twi *TWI, scope *Scope) {
+			from, to := scope.Get("from").(val_str), scope.Get("to").(val_str)
+			if _, err := os.Stat(from.Value); err == nil {
+				return
+			}
+			if err := os.Rename(to.Value, from.Value); err != nil {
+				panic(err)
+			}
+			if scope.Get("replaced").(val_bool).Value {
+				if err := os.WriteFile(to.Value, []byte(scope.Get("prev").(val_str).Value), 0664); err != nil {
+					panic(err)
+				}
+			}
 		},
 	},
 }
@@ -97,7 +200,26 @@ type TWI struct {
 	GlobalScope    *Scope
 	CurrentScope   *Scope
 	LastBreadCrumb *BreadCrumb
+	Journal        *Journal // nil unless skip blocks should be journaled to disk
+	Stdout         io.Writer
+	BufferOutput   bool      // stage output inside skip blocks until the outermost one finishes
+	FullUndoLog    bool      // keep every snapshot a skip block takes, not only the first of each location
+	Reverted       io.Writer // receives staged output that a reversal dropped, if not nil
+	staged         bytes.Buffer
+	committed      []outputSpan      // staged output that commit statements made permanent, in order
+	snapshots      map[location]bool // locations snapshot by the bread crumbs since the last barrier
 	reversing      bool
+	skips          []*BreadCrumb  // markers of the skip blocks currently executing, innermost last
+	skipOutput     []int          // staged output when each skip block currently executing began, innermost last
+	seizes         []int          // attempt number of each seize body currently executing, innermost last
+	pos            token.Position // position of the code being run, reported with runtime errors
+	natives        map[string]val_native_func
+	stores         map[string]*kvStore // key-value stores opened by kv_open, by path
+	tasks          *taskGroup          // shared by the tasks of the program
+	txn            *transaction        // atomic skip block being run, nil outside of one
+	held           []*transaction      // committed transactions the skip blocks they are in can still reverse
+	frames         []string            // functions currently being called, innermost last
+	debugger       *Debugger           // nil unless the program is being debugged
 }
 
 func NewTWI() *TWI {
@@ -108,17 +230,36 @@ func NewTWI() *TWI {
 
 	// Load in all native functions in global scope
 	// This means you could override them in more inner scopes..
-	for _, nf := range NATIVE_FUNCTIONS {
-		scope.Define(nf.Name, nf)
+	natives := make(map[string]val_native_func)
+	for _, group := range [][]val_native_func{NATIVE_FUNCTIONS, KV_NATIVES, HTTP_NATIVES, TASK_NATIVES} {
+		for _, nf := range group {
+			scope.Define(nf.Name, nf)
+			natives[nf.Name] = nf
+		}
+	}
+	for _, group := range [][]val_native_func{fileInverses, kvInverses} {
+		for _, nf := range group {
+			natives[nf.Name] = nf
+		}
 	}
+	scope.Define(runtimeErrorClass.Name, runtimeErrorClass)
 
 	return &TWI{
 		GlobalScope:    scope,
 		CurrentScope:   scope,
 		LastBreadCrumb: nil,
+		Stdout:         os.Stdout,
+		natives:        natives,
+		stores:         make(map[string]*kvStore),
+		tasks:          &taskGroup{},
 	}
 }
 
+/** Natives are looked up here rather than by scope so that inverses cannot be overridden */
+func (twi *TWI) nativeCall(name string, args ...value) native_call {
+	return native_call{Fn: twi.natives[name], Args: args}
+}
+
 func (twi *TWI) AddBreadCrumb(node ast.Node) {
 	if twi.reversing {
 		// only add bread crumbs when executing forwards
@@ -130,58 +271,114 @@ func (twi *TWI) AddBreadCrumb(node ast.Node) {
 
 		switch t := n.Lhs.(type) {
 		case *ast.IndexExpr:
-			name := t.Expr.ExprStr()
-			m := twi.evaluateExpr(t.Expr).(val_map)
-			idx := twi.evaluateExpr(t.Index)
-			twi.LastBreadCrumb = &BreadCrumb{
-				Prev:    twi.LastBreadCrumb,
-				Scope:   twi.CurrentScope.GetScope(name),
-				Name:    name,
-				PrevVal: val_index_val_pair{Index: idx, Value: m.Data[idx]},
+			container := twi.evaluateExpr(t.Expr)
+			if l, ok := container.(val_list); ok {
+				i := l.offset(twi.evaluateExpr(t.Index))
+				twi.leaveBreadCrumb(list_set{List: l, Index: i, Value: (*l.Data)[i]})
+				return
 			}
+			// the map itself is recorded rather than its name so that nested maps
+			// like bank[a][b] can be restored
+			m := container.(val_map)
+			idx := twi.evaluateExpr(t.Index)
+			prev, existed := m.Data[idx]
+			twi.leaveBreadCrumb(map_entry{Map: m, Key: idx, Value: prev, Existed: existed})
 		default:
 			name := t.ExprStr()
-			twi.LastBreadCrumb = &BreadCrumb{
-				Prev:    twi.LastBreadCrumb,
+			twi.pushBreadCrumb(&BreadCrumb{
 				Scope:   twi.CurrentScope.GetScope(name),
 				Name:    name,
 				PrevVal: twi.CurrentScope.Get(name),
-			}
-		}
-
-	case *ast.ExprStmt:
-		// only add bread crumb when the expression is annotated
-		if prevVal, ok := n.Annotations["undo"]; ok {
-			twi.LastBreadCrumb = &BreadCrumb{
-				Prev:    twi.LastBreadCrumb,
-				Scope:   twi.CurrentScope,
-				PrevVal: prevVal,
-			}
+			})
 		}
 
 	case *ast.IdentExpr:
 		name := n.Ident.Lexeme
-		twi.LastBreadCrumb = &BreadCrumb{
-			Prev:    twi.LastBreadCrumb,
+		twi.pushBreadCrumb(&BreadCrumb{
 			Scope:   twi.CurrentScope.GetScope(name),
 			Name:    name,
 			PrevVal: twi.CurrentScope.Get(name),
-		}
+		})
+	}
+}
+
+/** Records a mutation that is not tied to a single statement, such as a list method call */
+func (twi *TWI) leaveBreadCrumb(prevVal Reversible) {
+	if twi.reversing {
+		return
+	}
+	twi.pushBreadCrumb(&BreadCrumb{
+		Scope:   twi.CurrentScope,
+		PrevVal: prevVal,
+	})
+}
+
+/*
+*
+Links a bread crumb onto the chain, unless an earlier one already restores what it
+snapshots. With a journal open, it is written to disk first
+*/
+func (twi *TWI) pushBreadCrumb(bc *BreadCrumb) {
+	if twi.debugger != nil {
+		// stepping back needs every change, not only the ones a reversal needs
+		twi.debugger.pushed(bc)
+	}
+	if twi.redundant(bc) {
+		return
 	}
+	if twi.Journal != nil {
+		twi.Journal.Crumb(bc)
+	}
+	bc.Prev = twi.LastBreadCrumb
+	twi.LastBreadCrumb = bc
 }
 
 // ==== TODO: Temp for testing ====
 func (twi *TWI) Interpret(decl ast.Declaration) {
+	twi.tasks.lock.Lock()
+	defer twi.tasks.lock.Unlock()
 	twi.executeDecl(decl)
 }
 
-func (twi *TWI) RunMain() {
+/*
+*
+Runs main, returning the runtime error that stopped it outside of any skip block. main
+does not return until every task it spawned has finished
+*/
+func (twi *TWI) RunMain() (err error) {
 	call_expr := ast.CallExpr{
-		Callee: ast.NewIdentExpr(token.NewLexeme(token.Identifier, "main", token.Position{1, 1})),
+		Callee: ast.NewIdentExpr(token.NewLexeme(token.Identifier, "main", token.Position{Line: 1, Column: 1})),
 		Args:   []ast.Expression{},
 	}
-	twi.evaluateExpr(&call_expr)
-	// println(resp.(val_int).Value)
+	twi.tasks.lock.Lock()
+	_, err = twi.run(func() value { return twi.evaluateExpr(&call_expr) })
+	twi.tasks.lock.Unlock()
+	twi.tasks.wg.Wait()
+	return err
+}
+
+/** Runs the body of a task, returning what it returns or the error that stopped it outside of any skip block */
+func (twi *TWI) run(body func() value) (result value, err error) {
+	defer func() {
+		if panic_val := recover(); panic_val != nil {
+			// a runtime error no seize handled is still reported where it happened
+			if holder, ok := panic_val.(ReverseHolder); ok {
+				if rerr, ok := runtimeErrorOf(holder.Value); ok {
+					rerr.Trace = holder.Trace
+					err = rerr
+					return
+				}
+				err = holder.Trace
+				return
+			}
+			if rerr, ok := twi.runtimeError(panic_val); ok {
+				err = rerr
+				return
+			}
+			panic(panic_val)
+		}
+	}()
+	return body(), nil
 }
 
 // ====== TESTING =====
@@ -190,8 +387,10 @@ func (twi *TWI) RunMain() {
 func (twi *TWI) evaluateExpr(expr ast.Expression) value {
 	switch t := expr.(type) {
 	case *ast.LiteralExpr:
+		twi.pos = t.Position
 		return twi.visitLiteralExpr(t)
 	case *ast.IdentExpr:
+		twi.pos = t.Ident.Position
 		return twi.visitIdentExpr(t)
 	case *ast.BinaryOp:
 		return twi.visitBinaryExpr(t)
@@ -199,10 +398,16 @@ func (twi *TWI) evaluateExpr(expr ast.Expression) value {
 		return twi.visitGroupExpr(t)
 	case *ast.CallExpr:
 		return twi.visitCallExpr(t)
+	case *ast.SpawnExpr:
+		return twi.visitSpawnExpr(t)
 	case *ast.LitMapExpr:
 		return twi.visitLitMapExpr(t)
+	case *ast.LitListExpr:
+		return twi.visitLitListExpr(t)
 	case *ast.IndexExpr:
 		return twi.visitIndexExpr(t)
+	case *ast.DotExpr:
+		return twi.visitDotExpr(t)
 	default:
 		print(expr.ExprStr())
 		panic(fmt.Sprintf("Expression type cannot be evaluated: %+v", t))
@@ -230,12 +435,14 @@ func (twi *TWI) visitLiteralExpr(literal *ast.LiteralExpr) value {
 
 func (twi *TWI) visitIdentExpr(ident *ast.IdentExpr) value {
 	// pprintScope(twi.CurrentScope)
+	twi.touch(ident.Ident.Lexeme, false)
 	return twi.CurrentScope.Get(ident.Ident.Lexeme)
 }
 
 func (twi *TWI) visitBinaryExpr(bin *ast.BinaryOp) value {
 	lv := twi.evaluateExpr(bin.Lhs)
 	rv := twi.evaluateExpr(bin.Rhs)
+	twi.pos = bin.Op.Position
 
 	switch bin.Op.Kind {
 	case token.Plus:
@@ -271,6 +478,8 @@ func (twi *TWI) visitBinaryExpr(bin *ast.BinaryOp) value {
 		return val_bool{lv.(val_bool).Value && rv.(val_bool).Value}
 	case token.Or:
 		return val_bool{lv.(val_bool).Value || rv.(val_bool).Value}
+	case token.Caret:
+		return val_int{lv.(val_int).Value ^ rv.(val_int).Value}
 	}
 
 	panic(fmt.Sprintf("Unknown binary operation: %s", bin.Op.Kind))
@@ -292,8 +501,39 @@ func (twi *TWI) visitGroupExpr(group *ast.GroupExpr) value {
 }
 
 func (twi *TWI) visitIndexExpr(idxExpr *ast.IndexExpr) value {
-	m := twi.evaluateExpr(idxExpr.Expr).(val_map)
-	return m.Data[twi.evaluateExpr(idxExpr.Index)]
+	switch container := twi.evaluateExpr(idxExpr.Expr).(type) {
+	case val_list:
+		return (*container.Data)[container.offset(twi.evaluateExpr(idxExpr.Index))]
+	default:
+		m := container.(val_map)
+		key := twi.evaluateExpr(idxExpr.Index)
+		val, ok := m.Data[key]
+		if !ok {
+			panic(fmt.Sprintf("Key %s is not in the map", key.ToString()))
+		}
+		return val
+	}
+}
+
+/** Reads a field of an object */
+func (twi *TWI) visitDotExpr(dot *ast.DotExpr) value {
+	obj, ok := twi.evaluateExpr(dot.Expr).(val_object)
+	if !ok {
+		panic(fmt.Sprintf("Cannot read field %s of a non object", dot.Field.Ident.Lexeme))
+	}
+	field, ok := obj.Fields[dot.Field.Ident.Lexeme]
+	if !ok {
+		panic(fmt.Sprintf("%s has no field %s", obj.Class.Name, dot.Field.Ident.Lexeme))
+	}
+	return field
+}
+
+func (twi *TWI) visitLitListExpr(listVal *ast.LitListExpr) value {
+	elements := make([]value, 0, len(listVal.Elements))
+	for _, el := range listVal.Elements {
+		elements = append(elements, twi.evaluateExpr(el))
+	}
+	return newList(elements)
 }
 
 func (twi *TWI) visitLitMapExpr(mapVal *ast.LitMapExpr) value {
@@ -306,7 +546,45 @@ func (twi *TWI) visitLitMapExpr(mapVal *ast.LitMapExpr) value {
 	return val
 }
 
+/** Methods are only defined on builtin types for now */
+func (twi *TWI) visitMethodCall(dot *ast.DotExpr, args []ast.Expression) value {
+	receiver := twi.evaluateExpr(dot.Expr)
+	method := dot.Field.Ident.Lexeme
+
+	switch r := receiver.(type) {
+	case val_list:
+		if method != "len" {
+			twi.touchRoot(dot.Expr)
+		}
+		switch method {
+		case "push":
+			for _, arg := range args {
+				*r.Data = append(*r.Data, twi.evaluateExpr(arg))
+				twi.leaveBreadCrumb(list_push{List: r})
+			}
+			return val_void{}
+		case "pop":
+			last := len(*r.Data) - 1
+			if last < 0 {
+				panic("Cannot pop from an empty list")
+			}
+			popped := (*r.Data)[last]
+			*r.Data = (*r.Data)[:last]
+			twi.leaveBreadCrumb(list_pop{List: r, Value: popped})
+			return popped
+		case "len":
+			return val_int{len(*r.Data)}
+		}
+	}
+
+	panic(fmt.Sprintf("Unknown method %s on %s", method, receiver.ToString()))
+}
+
 func (twi *TWI) visitCallExpr(expr *ast.CallExpr) (return_val value) {
+	if dot, ok := expr.Callee.(*ast.DotExpr); ok {
+		return twi.visitMethodCall(dot, expr.Args)
+	}
+
 	// Resolved value we are calling
 	// Could be other_fn() or something more convoluted:
 	// fn_generator("hello")(" Alex") AKA call a fn returned from a fn
@@ -317,6 +595,18 @@ func (twi *TWI) visitCallExpr(expr *ast.CallExpr) (return_val value) {
 	for _, arg := range expr.Args {
 		args = append(args, twi.evaluateExpr(arg))
 	}
+	if ident, ok := expr.Callee.(*ast.IdentExpr); ok {
+		twi.pos = ident.Ident.Position
+	}
+
+	return twi.call(callee, args)
+}
+
+/** Calls a function value with already evaluated arguments */
+func (twi *TWI) call(callee value, args []value) (return_val value) {
+	if class, ok := callee.(val_class); ok {
+		return class.construct(args)
+	}
 
 	// Handle return values here
 	defer func() {
@@ -334,17 +624,26 @@ func (twi *TWI) visitCallExpr(expr *ast.CallExpr) (return_val value) {
 	switch fn := callee.(type) {
 	case val_func:
 		fn_scope := MakeFnScope(twi.GlobalScope, args, fn.Params)
-		twi.visitBlockStmt(&fn_scope, fn.Body)
+		twi.visitFuncBody(fn.Name, &fn_scope, fn.Body)
 		return val_void{}
 
 	case val_native_func:
-		var fn_scope Scope
-		if fn.Variadic {
-			fn_scope = MakeVariadicFnScope(twi.GlobalScope, args)
+		fn_scope := fn.scope(twi, args)
+		var effect *native_effect
+		if fn.Inverse != nil && len(twi.skips) > 0 && !twi.reversing {
+			effect = fn.Inverse(twi, &fn_scope)
+		}
+		if effect == nil {
+			fn.Fn(twi, &fn_scope)
+			break
+		}
+		// the bread crumb goes first so that it is journaled before the call happens
+		twi.leaveBreadCrumb(*effect)
+		if effect.Do != nil {
+			effect.Do.run(twi)
 		} else {
-			fn_scope = MakeFnScope(twi.GlobalScope, args, fn.Params)
+			fn.Fn(twi, &fn_scope)
 		}
-		fn.Fn(&fn_scope)
 
 	default:
 		panic(fmt.Sprintf("Trying to call a non function: %s", fn))
@@ -353,10 +652,28 @@ func (twi *TWI) visitCallExpr(expr *ast.CallExpr) (return_val value) {
 	return val_void{}
 }
 
+/** Name of the function being run, "" outside of any function */
+func (twi *TWI) function() string {
+	if len(twi.frames) == 0 {
+		return ""
+	}
+	return twi.frames[len(twi.frames)-1]
+}
+
+/** Runs the body of the named function in its own scope */
+func (twi *TWI) visitFuncBody(name string, scope *Scope, body *ast.BlockStmt) {
+	twi.frames = append(twi.frames, name)
+	defer func() { twi.frames = twi.frames[:len(twi.frames)-1] }()
+	twi.visitBlockStmt(scope, body)
+}
+
 /** === Expression Code Ends === */
 
 /** === Statement Code Begins === */
 func (twi *TWI) executeStmt(stmt ast.Statement) {
+	if twi.debugger != nil {
+		twi.debugger.reached(stmt)
+	}
 	switch t := stmt.(type) {
 	case *ast.ForStmt:
 		twi.visitForStmt(t)
@@ -369,7 +686,7 @@ func (twi *TWI) executeStmt(stmt ast.Statement) {
 	case *ast.ExprStmt:
 		twi.visitExprStmt(t)
 	case *ast.TypedDeclStmt:
-		twi.executeDecl(t.Decl)
+		twi.visitTypedDeclStmt(t)
 	case *ast.AssignmentStmt:
 		twi.visitAssignmentStmt(t)
 	case *ast.IncStmt:
@@ -378,12 +695,85 @@ func (twi *TWI) executeStmt(stmt ast.Statement) {
 		twi.visitSkipStmt(t)
 	case *ast.ReverseStmt:
 		twi.visitReverseStmt(t)
+	case *ast.SavepointStmt:
+		twi.visitSavepointStmt(t)
+	case *ast.RetryStmt:
+		twi.visitRetryStmt(t)
+	case *ast.CommitStmt:
+		twi.visitCommitStmt(t)
+	case *ast.SwapStmt:
+		twi.visitSwapStmt(t)
+	case *ast.CallStmt:
+		twi.visitCallStmt(t)
 	}
 }
 
 func (twi *TWI) visitExprStmt(stmt *ast.ExprStmt) {
-	twi.AddBreadCrumb(stmt)
-	twi.evaluateExpr(stmt.Expr)
+	twi.compensated(stmt.Annotations["undo"], func() value {
+		return twi.evaluateExpr(stmt.Expr)
+	})
+}
+
+func (twi *TWI) visitTypedDeclStmt(stmt *ast.TypedDeclStmt) {
+	if len(stmt.Annotations) == 0 {
+		twi.visitVarDecl(stmt.Decl)
+		return
+	}
+	val := twi.compensated(stmt.Annotations["undo"], func() value {
+		return twi.evaluateExpr(stmt.Decl.Value)
+	})
+	twi.define(twi.CurrentScope, stmt.Decl.Ident.Lexeme, val)
+}
+
+/*
+*
+Runs forward, leaving a bread crumb for its @undo annotation if it has one. The
+compensation is bound before forward runs, unless it refers to the value of forward as
+it, in which case it can only be bound once forward has returned
+*/
+func (twi *TWI) compensated(undo ast.Statement, forward func() value) value {
+	if undo == nil || twi.reversing {
+		return forward()
+	}
+	if !usesIt(undo) {
+		twi.leaveCompensation(undo, twi.CurrentScope)
+		return forward()
+	}
+	it := forward()
+	twi.leaveCompensation(undo, &Scope{twi.CurrentScope, map[string]value{"it": it}})
+	return it
+}
+
+/** Leaves a bread crumb for a compensation, binding the callee and arguments of a call now */
+func (twi *TWI) leaveCompensation(undo ast.Statement, scope *Scope) {
+	stmt, ok := undo.(*ast.ExprStmt)
+	if !ok {
+		twi.pushBreadCrumb(&BreadCrumb{Scope: scope, PrevVal: undo})
+		return
+	}
+	call, ok := stmt.Expr.(*ast.CallExpr)
+	if !ok {
+		twi.pushBreadCrumb(&BreadCrumb{Scope: scope, PrevVal: undo})
+		return
+	}
+	prev_scope := twi.CurrentScope
+	twi.CurrentScope = scope
+	defer func() { twi.CurrentScope = prev_scope }()
+	bound := bound_call{Call: call, Fn: twi.evaluateExpr(call.Callee)}
+	for _, arg := range call.Args {
+		bound.Args = append(bound.Args, twi.evaluateExpr(arg))
+	}
+	twi.pushBreadCrumb(&BreadCrumb{Scope: scope, PrevVal: bound})
+}
+
+func usesIt(undo ast.Statement) bool {
+	switch s := undo.(type) {
+	case *ast.ExprStmt:
+		return ast.Mentions(s.Expr, "it")
+	case *ast.AssignmentStmt:
+		return ast.Mentions(s.Lhs, "it") || ast.Mentions(s.Rhs, "it")
+	}
+	return false
 }
 
 func (twi *TWI) visitForStmt(stmt *ast.ForStmt) {
@@ -414,6 +804,7 @@ func (twi *TWI) visitIfStmt(stmt *ast.IfStmt) {
 
 	if result.Value {
 		twi.executeStmt(stmt.If.Body)
+		twi.assertFi(stmt, true)
 		return
 	}
 
@@ -422,6 +813,7 @@ func (twi *TWI) visitIfStmt(stmt *ast.IfStmt) {
 		result = twi.evaluateExpr(elif.Cond).(val_bool)
 		if result.Value {
 			twi.executeStmt(elif.Body)
+			twi.assertFi(stmt, false)
 			return
 		}
 	}
@@ -430,6 +822,17 @@ func (twi *TWI) visitIfStmt(stmt *ast.IfStmt) {
 	if stmt.Else != nil {
 		twi.executeStmt(stmt.Else)
 	}
+	twi.assertFi(stmt, false)
+}
+
+/** Checks that the fi assertion of an if, if it has one, tells which branch was taken */
+func (twi *TWI) assertFi(stmt *ast.IfStmt, took_if bool) {
+	if stmt.Fi == nil {
+		return
+	}
+	if fi := twi.evaluateExpr(stmt.Fi).(val_bool).Value; fi != took_if {
+		panic(fmt.Sprintf("fi assertion %s is %v after the if condition was %v", stmt.Fi.ExprStr(), fi, took_if))
+	}
 }
 
 /*
@@ -444,17 +847,81 @@ func (twi *TWI) visitReturnStmt(ret *ast.ReturnStmt) {
 }
 
 func (twi *TWI) visitAssignmentStmt(stmt *ast.AssignmentStmt) {
+	twi.assign(stmt.Lhs, twi.evaluateExpr(stmt.Rhs))
+}
+
+/** Assigns a value to a variable or an element of a list or map, leaving a bread crumb for it */
+func (twi *TWI) assign(lhs ast.Expression, val value) {
 	// TODO: This only works for simple name assignments
-	twi.AddBreadCrumb(stmt)
+	twi.touchRoot(lhs)
+	twi.AddBreadCrumb(&ast.AssignmentStmt{Lhs: lhs})
 
-	switch t := stmt.Lhs.(type) {
+	switch t := lhs.(type) {
 	case *ast.IndexExpr:
-		m := twi.evaluateExpr(t.Expr).(val_map)
-		m.Data[twi.evaluateExpr(t.Index)] = twi.evaluateExpr(stmt.Rhs)
+		twi.assignIndex(t, val)
 	default:
 		name := t.ExprStr()
-		twi.CurrentScope.Set(name, twi.evaluateExpr(stmt.Rhs))
+		twi.CurrentScope.Set(name, val)
+	}
+}
+
+func (twi *TWI) visitSwapStmt(stmt *ast.SwapStmt) {
+	lv, rv := twi.evaluateExpr(stmt.Lhs), twi.evaluateExpr(stmt.Rhs)
+	twi.assign(stmt.Lhs, rv)
+	twi.assign(stmt.Rhs, lv)
+}
+
+/*
+*
+Runs the body of a rev func for a call, or its inverse for an uncall. The arguments are
+passed by reference: the function works on copies that are assigned back once it is done.
+Inside a skip block, the bread crumbs of the call are replaced by one that makes the
+opposite call, so undoing the call does not need every value it overwrote.
+*/
+func (twi *TWI) visitCallStmt(stmt *ast.CallStmt) {
+	fn, ok := twi.evaluateExpr(stmt.Call.Callee).(val_func)
+	if !ok || fn.Inverse == nil {
+		panic(fmt.Sprintf("%s is not a rev func", stmt.Call.Callee.ExprStr()))
+	}
+	args := make([]value, 0, len(stmt.Call.Args))
+	for _, arg := range stmt.Call.Args {
+		args = append(args, twi.evaluateExpr(arg))
+	}
+
+	body := fn.Body
+	if stmt.Uncall() {
+		body = fn.Inverse
+	}
+	mark := twi.LastBreadCrumb
+	fn_scope := MakeFnScope(twi.GlobalScope, args, fn.Params)
+	twi.visitFuncBody(fn.Name, &fn_scope, body)
+	for i, arg := range stmt.Call.Args {
+		twi.assign(arg, fn_scope.Values[fn.Params[i]])
+	}
+
+	if len(twi.skips) > 0 && !twi.reversing {
+		twi.dropBreadCrumbs(mark)
+		twi.leaveBreadCrumb(rev_call{Call: stmt})
+	}
+}
+
+func (twi *TWI) assignIndex(lhs *ast.IndexExpr, val value) {
+	switch container := twi.evaluateExpr(lhs.Expr).(type) {
+	case val_list:
+		(*container.Data)[container.offset(twi.evaluateExpr(lhs.Index))] = val
+	default:
+		m := container.(val_map)
+		m.Data[twi.evaluateExpr(lhs.Index)] = val
+	}
+}
+
+func (twi *TWI) deleteKey(m val_map, key value) {
+	prev, existed := m.Data[key]
+	if !existed {
+		return
 	}
+	twi.leaveBreadCrumb(map_entry{Map: m, Key: key, Value: prev, Existed: true})
+	delete(m.Data, key)
 }
 
 func (twi *TWI) visitIncStmt(inc *ast.IncStmt) {
@@ -465,67 +932,306 @@ func (twi *TWI) visitIncStmt(inc *ast.IncStmt) {
 	case token.Decrement:
 		val = val.Subtract(val_int{1})
 	}
+	twi.touchRoot(inc.Expr)
 
 	switch t := inc.Expr.(type) {
 	case *ast.IdentExpr:
 		twi.AddBreadCrumb(t)
 		name := t.Ident.Lexeme
 		twi.CurrentScope.Set(name, val.(value))
+	case *ast.IndexExpr:
+		twi.AddBreadCrumb(&ast.AssignmentStmt{Lhs: t})
+		twi.assignIndex(t, val.(value))
 	}
 }
 
 func (twi *TWI) visitSkipStmt(stmt *ast.SkipStmt) {
+	// attempt counters are visible to the skip body and its seizes
+	scope := &Scope{twi.CurrentScope, make(map[string]value)}
+	for attempt := 1; ; attempt++ {
+		for _, retry := range stmt.Retries {
+			if retry.Counter != nil {
+				scope.Define(retry.Counter.Ident.Lexeme, val_int{attempt})
+			}
+		}
+		if !twi.attempt(stmt, scope, attempt) {
+			return
+		}
+	}
+}
+
+/*
+*
+Runs the body of a skip once and the seize that catches a reversal of it, returning true
+if the seize retried. The always clause runs last, after the bread crumbs of the body are
+committed or reversed and after the seize, however the attempt is left
+*/
+func (twi *TWI) attempt(stmt *ast.SkipStmt, scope *Scope, attempt int) (retried bool) {
+	if stmt.Always != nil {
+		defer twi.visitAlways(stmt.Always, scope)
+	}
+	var seize *ast.SeizeStmt
+	var reversed value
+	if stmt.Atomic != nil && twi.txn == nil {
+		seize, reversed = twi.attemptAtomic(stmt, scope)
+	} else {
+		// an atomic skip inside a transaction is part of it
+		seize, reversed = twi.attemptSkip(stmt, scope)
+	}
+	return seize != nil && twi.visitSeize(seize, reversed, scope, attempt)
+}
+
+/** Runs an always clause, then carries on leaving the skip the way it was being left */
+func (twi *TWI) visitAlways(always *ast.BlockStmt, scope *Scope) {
+	panic_val := recover()
+	// a reversal on its way to the enclosing skip waits for the always clause to run forwards
+	reversing := twi.reversing
+	twi.reversing = false
+	twi.visitBlockStmt(&Scope{scope, make(map[string]value)}, always)
+	twi.reversing = reversing
+	if panic_val != nil {
+		panic(panic_val)
+	}
+}
+
+/** Runs the body of a skip once, returning the seize that caught a reversal of it and the reversed value */
+func (twi *TWI) attemptSkip(stmt *ast.SkipStmt, scope *Scope) (caught *ast.SeizeStmt, reversed value) {
+	// Mark the start of the current skip
+	marker := &BreadCrumb{
+		SkipMarker: stmt,
+	}
+	twi.pushBreadCrumb(marker)
+	twi.skips = append(twi.skips, marker)
+	staged, held := twi.staged.Len(), len(twi.held)
+	twi.skipOutput = append(twi.skipOutput, staged)
+
 	// Handle return values here
 	defer func() {
+		twi.skips = twi.skips[:len(twi.skips)-1] // seize bodies run outside of this skip
+		twi.skipOutput = twi.skipOutput[:len(twi.skipOutput)-1]
 		if panic_val := recover(); panic_val != nil {
+			// runtime errors reverse the skip they happen in, so they can be seized
+			if err, ok := twi.runtimeError(panic_val); ok {
+				panic_val = ReverseHolder{err.value(), &UnhandledReversal{Value: err.Msg, Pos: err.Pos}}
+			}
+			// a transaction returning from its body has to be able to commit first
+			if _, ok := panic_val.(ReturnHolder); ok {
+				if c := twi.validate(stmt); c != nil {
+					panic_val = *c
+				}
+			}
 			switch holder := panic_val.(type) {
 			case ReturnHolder:
 				// Reset the last LastBreadCrumb to point to the bread crumb before this skip, without reverse executing
 				// This is necessary to support a return within a skip statement
-				for twi.LastBreadCrumb.SkipMarker != stmt {
-					twi.LastBreadCrumb = twi.LastBreadCrumb.Prev
+				if len(twi.skips) == 0 {
+					twi.commitBreadCrumbs(marker)
+					twi.journalEnd(journalCommit)
+					twi.flushOutput()
+					twi.release(0, true)
+				} else {
+					// the enclosing skip can still reverse what this one did
+					twi.journalEnd(journalDone)
 				}
 
-				twi.LastBreadCrumb = twi.LastBreadCrumb.Prev // Remove the SkipMarker
+			case conflict:
+				// the transaction is reversed without any seize seeing it, then run again
+				twi.unwind(marker, staged, held)
 
 			case ReverseHolder:
-				// Reverse any assignment statements Before the current SkipMarker
-				for twi.LastBreadCrumb.SkipMarker != stmt {
-					twi.LastBreadCrumb.Reverse(twi)
-					twi.LastBreadCrumb = twi.LastBreadCrumb.Prev
-				}
-
-				twi.LastBreadCrumb = twi.LastBreadCrumb.Prev // Remove the SkipMarker
+				compensations := twi.unwind(marker, staged, held)
 
+				prev_scope := twi.CurrentScope
+				twi.CurrentScope = scope
+				defer func() { twi.CurrentScope = prev_scope }()
 				for _, seize := range stmt.Seizes {
-					seize_val := twi.evaluateExpr(seize.Expr)
-
-					if seize_val.Equals(holder.Value) {
-						twi.executeStmt(seize.Body)
+					if twi.catches(seize, holder.Value) {
+						caught, reversed = seize, holder.Value
 						return // Exit the Panic Loop
 					}
-
 				}
-				twi.reversing = false
+				holder.Trace.Skips = append(holder.Trace.Skips, TracedSkip{stmt.Token.Position, twi.function()})
+				holder.Trace.Compensations = append(holder.Trace.Compensations, compensations...)
 			}
 			panic(panic_val) // Propagate panic
 		}
 	}()
 
-	// Mark the start of the current skip
-	twi.LastBreadCrumb = &BreadCrumb{
-		Prev:       twi.LastBreadCrumb,
-		SkipMarker: stmt,
+	twi.visitBlockStmt(&Scope{scope, make(map[string]value)}, stmt.Body)
+	if c := twi.validate(stmt); c != nil {
+		panic(*c)
 	}
 
-	twi.executeStmt(stmt.Body)
+	// Nothing can reverse the outermost skip block once it finishes
+	if len(twi.skips) == 1 {
+		twi.commitBreadCrumbs(marker)
+		twi.flushOutput()
+		twi.release(0, true)
+	}
+	twi.journalEnd(journalDone)
+	return nil, nil
+}
+
+/** Reverses the bread crumbs of a skip block, the output it staged and the transactions it held, returning the compensations that ran */
+func (twi *TWI) unwind(marker *BreadCrumb, staged, held int) []string {
+	// Reverse any assignment statements Before the current SkipMarker
+	compensations := twi.reverseTo(marker)
+	twi.LastBreadCrumb = marker.Prev // Remove the SkipMarker
+	twi.journalEnd(journalReversed)
+	twi.revertOutput(staged)
+	twi.release(held, false)
+	if len(twi.skips) == 0 {
+		twi.flushOutput() // output that was committed
+	}
+	return compensations
+}
+
+/** True when seize handles a reversal of val, by type if it binds the value or else by value */
+func (twi *TWI) catches(seize *ast.SeizeStmt, val value) bool {
+	switch {
+	case seize.Name != nil:
+		return isType(val, seize.Type)
+	case seize.Expr == nil:
+		return true
+	default:
+		return twi.evaluateExpr(seize.Expr).Equals(val)
+	}
+}
+
+/** Runs the body of a seize, returning true if it retried the skip it belongs to */
+func (twi *TWI) visitSeize(seize *ast.SeizeStmt, reversed value, scope *Scope, attempt int) (retried bool) {
+	twi.seizes = append(twi.seizes, attempt)
+	defer func() {
+		twi.seizes = twi.seizes[:len(twi.seizes)-1]
+		if panic_val := recover(); panic_val != nil {
+			if _, ok := panic_val.(RetryHolder); ok {
+				retried = true
+				return
+			}
+			panic(panic_val)
+		}
+	}()
+	body := &Scope{scope, make(map[string]value)}
+	if seize.Name != nil {
+		body.Define(seize.Name.Ident.Lexeme, reversed)
+	}
+	twi.visitBlockStmt(body, seize.Body)
+	return false
+}
+
+/** Reverses bread crumbs, most recent first, until last is the most recent one, returning the compensations that ran */
+func (twi *TWI) reverseTo(last *BreadCrumb) (compensations []string) {
+	twi.snapshots = nil
+	twi.reversing = true
+	for twi.LastBreadCrumb != last {
+		if twi.debugger != nil {
+			twi.debugger.reversing(twi.LastBreadCrumb)
+		}
+		twi.LastBreadCrumb.Reverse(twi)
+		if compensation := twi.LastBreadCrumb.compensation(); compensation != "" {
+			compensations = append(compensations, compensation)
+		}
+		if twi.Journal != nil {
+			twi.Journal.Undone(twi.LastBreadCrumb)
+		}
+		twi.LastBreadCrumb = twi.LastBreadCrumb.Prev
+	}
+	twi.reversing = false // whatever runs next runs forwards
+	return compensations
+}
+
+/** Drops the bread crumbs of a skip block without reversing them, making its effects permanent */
+func (twi *TWI) commitBreadCrumbs(marker *BreadCrumb) {
+	twi.dropBreadCrumbs(marker)
+	twi.LastBreadCrumb = marker.Prev // Remove the SkipMarker
+}
+
+/** Drops bread crumbs without reversing them until last is the most recent one */
+func (twi *TWI) dropBreadCrumbs(last *BreadCrumb) {
+	twi.snapshots = nil
+	for twi.LastBreadCrumb != last {
+		if effect, ok := twi.LastBreadCrumb.PrevVal.(native_effect); ok && effect.Commit != nil {
+			effect.Commit.run(twi)
+		}
+		twi.LastBreadCrumb = twi.LastBreadCrumb.Prev
+	}
+	// the changes to key-value stores the bread crumbs committed are written together
+	twi.syncStores()
+}
+
+func (twi *TWI) journalEnd(status string) {
+	if twi.Journal != nil {
+		twi.Journal.End(status)
+	}
 }
 
 func (twi *TWI) visitReverseStmt(rev *ast.ReverseStmt) {
+	if rev.To != nil {
+		twi.reverseToSavepoint(rev)
+		return
+	}
+
 	// Handle reverse values here
-	val := twi.evaluateExpr(rev.Expr)
+	var val value = val_void{}
+	if rev.Expr != nil {
+		val = twi.evaluateExpr(rev.Expr)
+	}
 	twi.reversing = true
-	panic(ReverseHolder{val})
+	panic(ReverseHolder{val, &UnhandledReversal{Value: val.ToString(), Pos: rev.Token.Position}})
+}
+
+/** Runs the skip body again, unless the seize it is in belongs to its last allowed attempt */
+func (twi *TWI) visitRetryStmt(stmt *ast.RetryStmt) {
+	if len(twi.seizes) == 0 {
+		panic("retry outside of a seize")
+	}
+	attempt := twi.seizes[len(twi.seizes)-1]
+	if stmt.Max != nil && attempt >= twi.evaluateExpr(stmt.Max).(val_int).Value {
+		return
+	}
+	panic(RetryHolder{})
+}
+
+func (twi *TWI) visitSavepointStmt(stmt *ast.SavepointStmt) {
+	name := twi.evaluateExpr(stmt.Name)
+	if len(twi.skips) == 0 {
+		panic(fmt.Sprintf("savepoint %s outside of a skip block", name.ToString()))
+	}
+	twi.leaveBreadCrumb(savepoint{Name: name, Skip: twi.skips[len(twi.skips)-1], Output: twi.staged.Len()})
+}
+
+/** Makes everything the current skip block did so far permanent, a reversal only undoes what comes after */
+func (twi *TWI) visitCommitStmt(stmt *ast.CommitStmt) {
+	if len(twi.skips) == 0 {
+		panic("commit outside of a skip block")
+	}
+	if twi.Journal != nil {
+		twi.Journal.Commit()
+	}
+	twi.dropBreadCrumbs(twi.skips[len(twi.skips)-1])
+	twi.commitOutput(twi.skipOutput[len(twi.skipOutput)-1])
+}
+
+/*
+*
+Reverses the current skip block back to its most recent savepoint with the given name,
+then carries on after the reverse statement. The savepoint stays in place, so it can be
+reversed to again and the whole skip block can still be reversed.
+*/
+func (twi *TWI) reverseToSavepoint(rev *ast.ReverseStmt) {
+	name := twi.evaluateExpr(rev.To)
+	if len(twi.skips) == 0 {
+		panic(fmt.Sprintf("reverse to %s outside of a skip block", name.ToString()))
+	}
+	skip := twi.skips[len(twi.skips)-1]
+	for bc := twi.LastBreadCrumb; bc != skip; bc = bc.Prev {
+		if sp, ok := bc.PrevVal.(savepoint); ok && sp.Skip == skip && sp.Name.Equals(name) {
+			twi.reverseTo(bc)
+			twi.revertOutput(sp.Output)
+			return
+		}
+	}
+	panic(fmt.Sprintf("no savepoint %s in the current skip block", name.ToString()))
 }
 
 /** === Statement Code Ends === */
@@ -537,9 +1243,22 @@ func (twi *TWI) executeDecl(decl ast.Declaration) {
 		twi.visitFuncDecl(t)
 	case *ast.VarDecl:
 		twi.visitVarDecl(t)
+	case *ast.ClassDecl:
+		twi.visitClassDecl(t)
 	}
 }
 
+/** Only the members of a class are supported, its methods are ignored */
+func (twi *TWI) visitClassDecl(class_decl *ast.ClassDecl) {
+	class := val_class{Name: class_decl.Name.Lexeme}
+	for _, decl := range class_decl.Body {
+		if member, ok := decl.(*ast.MemberDecl); ok {
+			class.Members = append(class.Members, member.Name.Lexeme)
+		}
+	}
+	twi.define(twi.CurrentScope, class.Name, class)
+}
+
 func (twi *TWI) visitFuncDecl(fn_decl *ast.FuncDecl) {
 	param_names := []string{}
 
@@ -552,15 +1271,31 @@ func (twi *TWI) visitFuncDecl(fn_decl *ast.FuncDecl) {
 		Params: param_names,
 		Body:   fn_decl.Body,
 	}
+	if fn_decl.Rev {
+		fn.Inverse = ast.Invert(fn_decl.Body).(*ast.BlockStmt)
+	}
 
-	twi.CurrentScope.Define(fn.Name, fn)
+	twi.define(twi.CurrentScope, fn.Name, fn)
 }
 
 func (twi *TWI) visitVarDecl(var_decl *ast.VarDecl) {
 	scope := twi.CurrentScope
-	scope.Define(var_decl.Ident.Lexeme, twi.evaluateExpr(var_decl.Value))
+	twi.define(scope, var_decl.Ident.Lexeme, twi.evaluateExpr(var_decl.Value))
 
 	// pprintScope(twi.CurrentScope)
 }
 
+/** Binds name in scope, leaving a bread crumb that undoes the binding */
+func (twi *TWI) define(scope *Scope, name string, val value) {
+	if !twi.reversing {
+		prev, existed := scope.Values[name]
+		twi.pushBreadCrumb(&BreadCrumb{
+			Scope:   scope,
+			Name:    name,
+			PrevVal: declaration{Value: prev, Existed: existed},
+		})
+	}
+	scope.Define(name, val)
+}
+
 /** === Declaration Code Ends === */
@@ -0,0 +1,387 @@
+package interpreter
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/pcen/ape/ape/ast"
+)
+
+/** How a skip block ended, recorded in the journal */
+const (
+	journalDone     = "done"     // the body finished, its effects now belong to the enclosing skip
+	journalCommit   = "commit"   // the body returned, its effects can no longer be reversed
+	journalReversed = "reversed" // every bread crumb in the block was reversed
+)
+
+/** Serialized form of a value so that compensation arguments survive a restart */
+type journalValue struct {
+	Kind  string         `json:"kind"`
+	Str   string         `json:"str,omitempty"`
+	Int   int            `json:"int,omitempty"`
+	Float float64        `json:"float,omitempty"`
+	Bool  bool           `json:"bool,omitempty"`
+	Elems []journalValue `json:"elems,omitempty"` // list elements, or alternating map keys or field names and values
+}
+
+func encodeValue(v value) journalValue {
+	switch t := v.(type) {
+	case val_str:
+		return journalValue{Kind: "str", Str: t.Value}
+	case val_int:
+		return journalValue{Kind: "int", Int: t.Value}
+	case val_rational:
+		return journalValue{Kind: "rational", Float: t.Value}
+	case val_bool:
+		return journalValue{Kind: "bool", Bool: t.Value}
+	case val_list:
+		jv := journalValue{Kind: "list"}
+		for _, el := range *t.Data {
+			jv.Elems = append(jv.Elems, encodeValue(el))
+		}
+		return jv
+	case val_map:
+		jv := journalValue{Kind: "map"}
+		for k, el := range t.Data {
+			jv.Elems = append(jv.Elems, encodeValue(k), encodeValue(el))
+		}
+		return jv
+	case val_object:
+		jv := journalValue{Kind: "object", Str: t.Class.Name}
+		for name, el := range t.Fields {
+			jv.Elems = append(jv.Elems, encodeValue(val_str{name}), encodeValue(el))
+		}
+		return jv
+	case val_func:
+		return journalValue{Kind: "func", Str: t.Name}
+	case val_native_func:
+		return journalValue{Kind: "func", Str: t.Name}
+	default:
+		return journalValue{Kind: "void"}
+	}
+}
+
+func (jv journalValue) decode(twi *TWI) value {
+	switch jv.Kind {
+	case "str":
+		return val_str{jv.Str}
+	case "int":
+		return val_int{jv.Int}
+	case "rational":
+		return val_rational{jv.Float}
+	case "bool":
+		return val_bool{jv.Bool}
+	case "list":
+		elements := make([]value, 0, len(jv.Elems))
+		for _, el := range jv.Elems {
+			elements = append(elements, el.decode(twi))
+		}
+		return newList(elements)
+	case "map":
+		m := val_map{Data: map[value]value{}}
+		for i := 0; i+1 < len(jv.Elems); i += 2 {
+			m.Data[jv.Elems[i].decode(twi)] = jv.Elems[i+1].decode(twi)
+		}
+		return m
+	case "object":
+		// the class is restored by name from the reloaded script
+		obj := val_object{Class: twi.GlobalScope.Get(jv.Str).(val_class), Fields: map[string]value{}}
+		for i := 0; i+1 < len(jv.Elems); i += 2 {
+			obj.Fields[jv.Elems[i].Str] = jv.Elems[i+1].decode(twi)
+		}
+		return obj
+	case "func":
+		// functions are restored by name from the reloaded script
+		return twi.GlobalScope.Get(jv.Str)
+	default:
+		return val_void{}
+	}
+}
+
+type journalRecord struct {
+	Seq    int            `json:"seq"`
+	Op     string         `json:"op"`               // begin, crumb, undo, undone, commit or end
+	Status string         `json:"status,omitempty"` // how a skip ended
+	Kind   string         `json:"kind,omitempty"`   // what a crumb records
+	Name   string         `json:"name,omitempty"`   // variable of a crumb, function of an undo
+	Args   []journalValue `json:"args,omitempty"`   // arguments to an undo call
+	Value  *journalValue  `json:"value,omitempty"`  // previous value of a variable
+	Ref    int            `json:"ref,omitempty"`    // seq of the undo an undone record refers to, never 0 since a begin comes first
+	Pos    string         `json:"pos,omitempty"`
+}
+
+/*
+*
+Write-ahead log of the bread crumb chain. Every bread crumb is written (and synced)
+before the statement it belongs to runs, so if the process dies inside a skip block,
+Recover can run the @undo compensations that were still pending on the next start.
+*/
+type Journal struct {
+	path  string
+	file  *os.File
+	enc   *json.Encoder
+	seq   int
+	depth int
+	undos map[*BreadCrumb]int
+}
+
+/** The journal of a script lives next to it */
+func JournalPath(script string) string {
+	return script + ".journal"
+}
+
+func readJournal(path string) ([]journalRecord, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []journalRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		var rec journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// the last record may be torn if the process died while writing it
+			break
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+/** Opens the journal at path for appending, creating it if it does not exist */
+func OpenJournal(path string) (*Journal, error) {
+	records, err := readJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0664)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{
+		path:  path,
+		file:  file,
+		enc:   json.NewEncoder(file),
+		undos: make(map[*BreadCrumb]int),
+	}
+	if len(records) > 0 {
+		j.seq = records[len(records)-1].Seq + 1
+	}
+	return j, nil
+}
+
+/** True when the journal at path has compensations that were never run */
+func JournalPending(path string) (bool, error) {
+	paths, err := journalFiles(path)
+	if err != nil {
+		return false, err
+	}
+	for _, path := range paths {
+		records, err := readJournal(path)
+		if err != nil || len(pendingUndos(records)) > 0 {
+			return err == nil, err
+		}
+	}
+	return false, nil
+}
+
+func (j *Journal) write(rec journalRecord) int {
+	rec.Seq = j.seq
+	j.seq++
+	if err := j.enc.Encode(rec); err != nil {
+		panic(err)
+	}
+	if err := j.file.Sync(); err != nil {
+		panic(err)
+	}
+	return rec.Seq
+}
+
+/** Records a bread crumb before the forward action it reverses takes place */
+func (j *Journal) Crumb(bc *BreadCrumb) {
+	if bc.SkipMarker != nil {
+		j.depth++
+		j.write(journalRecord{Op: "begin", Pos: bc.SkipMarker.Token.Position.String()})
+		return
+	}
+	if j.depth == 0 {
+		// nothing outside of a skip block is ever reversed, so there is nothing to recover
+		return
+	}
+
+	switch t := bc.PrevVal.(type) {
+	case bound_call:
+		// the function is looked up again by name after a restart
+		rec := journalRecord{Op: "undo", Pos: ast.Pos(t.Call).String()}
+		switch fn := t.Fn.(type) {
+		case val_func:
+			rec.Name = fn.Name
+		case val_native_func:
+			rec.Name = fn.Name
+		}
+		for _, arg := range t.Args {
+			rec.Args = append(rec.Args, encodeValue(arg))
+		}
+		j.undos[bc] = j.write(rec)
+	case ast.Statement:
+		// only calls can be replayed after a restart
+		j.undos[bc] = j.write(journalRecord{Op: "undo", Pos: ast.Pos(t).String()})
+	case native_effect:
+		rec := journalRecord{Op: "undo", Kind: "native", Name: t.Undo.Fn.Name}
+		for _, arg := range t.Undo.Args {
+			rec.Args = append(rec.Args, encodeValue(arg))
+		}
+		j.undos[bc] = j.write(rec)
+	case value:
+		prev := encodeValue(t)
+		j.write(journalRecord{Op: "crumb", Kind: "assign", Name: bc.Name, Value: &prev})
+	case declaration:
+		rec := journalRecord{Op: "crumb", Kind: "declare", Name: bc.Name}
+		if t.Existed {
+			prev := encodeValue(t.Value)
+			rec.Value = &prev
+		}
+		j.write(rec)
+	default:
+		j.write(journalRecord{Op: "crumb", Kind: fmt.Sprintf("%T", t)})
+	}
+}
+
+/** Records that the compensation of a bread crumb has run */
+func (j *Journal) Undone(bc *BreadCrumb) {
+	if seq, ok := j.undos[bc]; ok {
+		j.write(journalRecord{Op: "undone", Ref: seq})
+		delete(j.undos, bc)
+	}
+}
+
+/** Records that the undos of the innermost open skip block so far can no longer run */
+func (j *Journal) Commit() {
+	j.write(journalRecord{Op: "commit"})
+}
+
+/** Records how the innermost open skip block ended */
+func (j *Journal) End(status string) {
+	j.write(journalRecord{Op: "end", Status: status})
+	j.depth--
+	if j.depth == 0 {
+		j.undos = make(map[*BreadCrumb]int)
+	}
+}
+
+/** Closes the journal and deletes it, once nothing in it can still be pending */
+func (j *Journal) Remove() error {
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(j.path)
+}
+
+/** Finds the undo records of skip blocks that did not finish, in the order they were written */
+func pendingUndos(records []journalRecord) []journalRecord {
+	var frames []int // index into undos at which each open skip block began
+	var undos []journalRecord
+	undone := make(map[int]bool)
+
+	for _, rec := range records {
+		switch rec.Op {
+		case "begin":
+			frames = append(frames, len(undos))
+		case "undo":
+			// compensations outside of any skip block are never run
+			if len(frames) > 0 {
+				undos = append(undos, rec)
+			}
+		case "undone":
+			undone[rec.Ref] = true
+		case "commit":
+			if len(frames) > 0 {
+				undos = undos[:frames[len(frames)-1]]
+			}
+		case "end":
+			if len(frames) == 0 {
+				continue
+			}
+			start := frames[len(frames)-1]
+			frames = frames[:len(frames)-1]
+			// a finished nested skip can still be reversed by the skip enclosing it
+			if rec.Status != journalDone || len(frames) == 0 {
+				undos = undos[:start]
+			}
+		}
+	}
+
+	pending := make([]journalRecord, 0, len(undos))
+	for _, rec := range undos {
+		if !undone[rec.Seq] {
+			pending = append(pending, rec)
+		}
+	}
+	return pending
+}
+
+/*
+*
+Runs the pending compensations in the journal at path and in the journals of the tasks
+its program spawned, then removes the journals. Each journal is recovered most recent
+compensation first, the journals of later tasks before those of earlier ones and the
+program's own last. The script the journal belongs to must already be interpreted so
+that the compensating functions are defined.
+*/
+func (twi *TWI) Recover(path string) error {
+	paths, err := journalFiles(path)
+	if err != nil {
+		return err
+	}
+	twi.tasks.lock.Lock()
+	defer twi.tasks.lock.Unlock()
+	for i := len(paths) - 1; i >= 0; i-- {
+		if err := twi.recoverJournal(paths[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (twi *TWI) recoverJournal(path string) error {
+	records, err := readJournal(path)
+	if err != nil {
+		return err
+	}
+	j, err := OpenJournal(path)
+	if err != nil {
+		return err
+	}
+
+	pending := pendingUndos(records)
+	twi.reversing = true
+	for i := len(pending) - 1; i >= 0; i-- {
+		rec := pending[i]
+		if rec.Name == "" {
+			fmt.Printf("cannot recover compensation %v, it is not a call to a named function\n", rec.Seq)
+			continue
+		}
+		args := make([]value, 0, len(rec.Args))
+		for _, arg := range rec.Args {
+			args = append(args, arg.decode(twi))
+		}
+		if rec.Kind == "native" {
+			native_call{Fn: twi.natives[rec.Name], Args: args}.run(twi)
+		} else {
+			twi.call(twi.GlobalScope.Get(rec.Name), args)
+		}
+		// record progress so that a crash during recovery does not run it twice
+		j.write(journalRecord{Op: "undone", Ref: rec.Seq})
+	}
+	twi.reversing = false
+
+	return j.Remove()
+}
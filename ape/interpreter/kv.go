@@ -0,0 +1,251 @@
+package interpreter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+/** A change to a key that a skip block has made but not yet committed */
+type kv_staged struct {
+	Value   string
+	Deleted bool
+}
+
+/*
+*
+A key-value store kept in a file. Puts and deletes inside a skip block are staged in
+memory, so they are only seen by the task that made them until its outermost skip block
+finishes, when they are written to the file at once. A reversal drops them, and so does a
+crash, which leaves the file as it was when the last skip block finished.
+*/
+type kvStore struct {
+	path      string
+	committed map[string]string
+	staged    map[*TWI]map[string]kv_staged // by the task that staged them
+	dirty     bool                          // committed has changes the file does not
+}
+
+func openStore(path string) *kvStore {
+	s := &kvStore{path: path, committed: make(map[string]string), staged: make(map[*TWI]map[string]kv_staged)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s
+	} else if err != nil {
+		panic(err)
+	}
+	if err := json.Unmarshal(data, &s.committed); err != nil {
+		panic(fmt.Sprintf("kv store %v is corrupt: %v", path, err))
+	}
+	return s
+}
+
+/** The value of a key as a task sees it, its own staged changes included */
+func (s *kvStore) get(twi *TWI, key string) (string, bool) {
+	if staged, ok := s.staged[twi][key]; ok {
+		return staged.Value, !staged.Deleted
+	}
+	val, ok := s.committed[key]
+	return val, ok
+}
+
+/** Makes the change a task staged to a key part of what is written to the file next */
+func (s *kvStore) promote(twi *TWI, key string) {
+	staged, ok := s.staged[twi][key]
+	if !ok {
+		return
+	}
+	s.unstage(twi, key)
+	if staged.Deleted {
+		delete(s.committed, key)
+	} else {
+		s.committed[key] = staged.Value
+	}
+	s.dirty = true
+}
+
+/** The changes a task has staged to the store, made on its first one */
+func (s *kvStore) stagedBy(twi *TWI) map[string]kv_staged {
+	staged, ok := s.staged[twi]
+	if !ok {
+		staged = make(map[string]kv_staged)
+		s.staged[twi] = staged
+	}
+	return staged
+}
+
+/** Drops the change a task staged to a key */
+func (s *kvStore) unstage(twi *TWI, key string) {
+	delete(s.staged[twi], key)
+	if len(s.staged[twi]) == 0 {
+		delete(s.staged, twi)
+	}
+}
+
+/** Replaces the file with the committed contents, so it always holds one or the other */
+func (s *kvStore) sync() {
+	if !s.dirty {
+		return
+	}
+	data, err := json.Marshal(s.committed)
+	if err != nil {
+		panic(err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		panic(err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		panic(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		panic(err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		panic(err)
+	}
+	s.dirty = false
+}
+
+/** Looks up the store a handle from kv_open refers to */
+func (twi *TWI) store(handle value) *kvStore {
+	path := handle.(val_str).Value
+	s, ok := twi.stores[path]
+	if !ok {
+		panic(fmt.Sprintf("kv store %v is not open", path))
+	}
+	return s
+}
+
+/** Writes every store with changes that were committed since they were last written */
+func (twi *TWI) syncStores() {
+	for _, s := range twi.stores {
+		s.sync()
+	}
+}
+
+/** Whether a change to a store joins the skip block the program is in, rather than being written at once */
+func (twi *TWI) staging() bool {
+	return len(twi.skips) > 0 && !twi.reversing
+}
+
+/** Stages or writes a change to a key, depending on whether it is made inside a skip block */
+func (twi *TWI) kvChange(handle value, key string, change kv_staged) {
+	s := twi.store(handle)
+	if twi.staging() {
+		s.stagedBy(twi)[key] = change
+		return
+	}
+	if change.Deleted {
+		delete(s.committed, key)
+	} else {
+		s.committed[key] = change.Value
+	}
+	s.dirty = true
+	s.sync()
+}
+
+/** The bread crumb of a change to a key, which restores the staged change it replaces */
+func (twi *TWI) kvInverse(scope *Scope) *native_effect {
+	handle, key := scope.Get("db"), scope.Get("key")
+	prev, staged := twi.store(handle).staged[twi][key.(val_str).Value]
+	commit := twi.nativeCall("kv_promote", handle, key)
+	return &native_effect{
+		Undo:   twi.nativeCall("kv_unstage", handle, key, val_bool{staged}, val_bool{prev.Deleted}, val_str{prev.Value}),
+		Commit: &commit,
+	}
+}
+
+/** Natives of the key-value store */
+var KV_NATIVES = []val_native_func{
+	{
+		// opening a store is idempotent, the handle is the path of its file
+		Name:   "kv_open",
+		Params: []string{"path"},
+		Fn: func(twi *TWI, scope *Scope) {
+			path := filepath.Clean(scope.Get("path").(val_str).Value)
+			if _, ok := twi.stores[path]; !ok {
+				twi.stores[path] = openStore(path)
+			}
+			panic(ReturnHolder{Value: val_str{path}})
+		},
+	},
+	{
+		// keys that are not in the store have the value ""
+		Name:   "kv_get",
+		Params: []string{"db", "key"},
+		Fn: func(twi *TWI, scope *Scope) {
+			val, _ := twi.store(scope.Get("db")).get(twi, scope.Get("key").(val_str).Value)
+			panic(ReturnHolder{Value: val_str{val}})
+		},
+	},
+	{
+		Name:   "kv_has",
+		Params: []string{"db", "key"},
+		Fn: func(twi *TWI, scope *Scope) {
+			_, ok := twi.store(scope.Get("db")).get(twi, scope.Get("key").(val_str).Value)
+			panic(ReturnHolder{Value: val_bool{ok}})
+		},
+	},
+	{
+		Name:   "kv_put",
+		Params: []string{"db", "key", "value"},
+		Fn: func(twi *TWI, scope *Scope) {
+			twi.kvChange(scope.Get("db"), scope.Get("key").(val_str).Value, kv_staged{Value: scope.Get("value").(val_str).Value})
+		},
+		Inverse: func(twi *TWI, scope *Scope) *native_effect {
+			return twi.kvInverse(scope)
+		},
+	},
+	{
+		Name:   "kv_delete",
+		Params: []string{"db", "key"},
+		Fn: func(twi *TWI, scope *Scope) {
+			twi.kvChange(scope.Get("db"), scope.Get("key").(val_str).Value, kv_staged{Deleted: true})
+		},
+		Inverse: func(twi *TWI, scope *Scope) *native_effect {
+			return twi.kvInverse(scope)
+		},
+	},
+}
+
+/*
+*
+Natives only the bread crumbs of kv_put and kv_delete call, which scripts cannot see. They
+do nothing to a store that is not open, as when a crashed run is recovered, since staged
+changes did not outlive the crash
+*/
+var kvInverses = []val_native_func{
+	{
+		Name:   "kv_unstage",
+		Params: []string{"db", "key", "staged", "deleted", "value"},
+		Fn: func(twi *TWI, scope *Scope) {
+			s, ok := twi.stores[scope.Get("db").(val_str).Value]
+			if !ok {
+				return
+			}
+			key := scope.Get("key").(val_str).Value
+			if !scope.Get("staged").(val_bool).Value {
+				s.unstage(twi, key)
+				return
+			}
+			s.stagedBy(twi)[key] = kv_staged{Value: scope.Get("value").(val_str).Value, Deleted: scope.Get("deleted").(val_bool).Value}
+		},
+	},
+	{
+		Name:   "kv_promote",
+		Params: []string{"db", "key"},
+		Fn: func(twi *TWI, scope *Scope) {
+			if s, ok := twi.stores[scope.Get("db").(val_str).Value]; ok {
+				s.promote(twi, scope.Get("key").(val_str).Value)
+			}
+		},
+	},
+}
@@ -0,0 +1,72 @@
+package interpreter
+
+import (
+	"io"
+)
+
+/** Writes program output, staging it instead while buffering output inside a skip block */
+func (twi *TWI) print(s string) {
+	if twi.BufferOutput && len(twi.skips) > 0 {
+		twi.staged.WriteString(s)
+		return
+	}
+	io.WriteString(twi.Stdout, s)
+}
+
+/** Staged output between start and end, which no reversal drops */
+type outputSpan struct {
+	start, end int
+}
+
+/*
+*
+Drops the output staged after mark, passing it on to the reverted output if there is one.
+Output that a commit made permanent is kept, in the order it was printed
+*/
+func (twi *TWI) revertOutput(mark int) {
+	data := twi.staged.Bytes()
+	var kept []byte
+	committed := make([]outputSpan, 0, len(twi.committed))
+	from := mark
+	for _, span := range twi.committed {
+		if span.end <= mark {
+			committed = append(committed, span)
+			continue
+		}
+		start := span.start
+		if start < mark {
+			start = mark
+		}
+		twi.revert(data[from:start])
+		committed = append(committed, outputSpan{mark + len(kept), mark + len(kept) + span.end - start})
+		kept = append(kept, data[start:span.end]...)
+		from = span.end
+	}
+	twi.revert(data[from:])
+	twi.staged.Truncate(mark)
+	twi.staged.Write(kept)
+	twi.committed = committed
+}
+
+/** Passes output a reversal dropped on to the reverted output, if there is one */
+func (twi *TWI) revert(output []byte) {
+	if twi.Reverted != nil && len(output) > 0 {
+		twi.Reverted.Write(output)
+	}
+}
+
+/** Makes the output staged since start permanent, start being where the innermost skip block's output began */
+func (twi *TWI) commitOutput(start int) {
+	committed := twi.committed
+	for len(committed) > 0 && committed[len(committed)-1].start >= start {
+		committed = committed[:len(committed)-1]
+	}
+	twi.committed = append(committed, outputSpan{start, twi.staged.Len()})
+}
+
+/** Writes the staged output once the outermost skip block can no longer be reversed */
+func (twi *TWI) flushOutput() {
+	twi.Stdout.Write(twi.staged.Bytes())
+	twi.staged.Reset()
+	twi.committed = nil
+}
@@ -1,6 +1,10 @@
 package interpreter
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
+
 	"github.com/pcen/ape/ape/ast"
 )
 
@@ -12,11 +16,85 @@ type ReturnHolder struct {
 /** Wraps the value of a reverse statement*/
 type ReverseHolder struct {
 	Value value
+	Trace *UnhandledReversal // filled in as the reversal leaves skips no seize of which handles it
 }
 
-/** Empty interface but in reality, only Value and ReverseAnnotation should be used for this */
+/** Raised by a retry statement, caught by the seize body it is in */
+type RetryHolder struct{}
+
+/** Empty interface but in reality, only Value, ReverseAnnotation, native effects, savepoints and the list/map edits below should be used for this */
 type Reversible interface{}
 
+/** Records xs.push(v), reversed by dropping the pushed element */
+type list_push struct {
+	List val_list
+}
+
+/** Records xs[i] = v, reversed by putting back the overwritten element */
+type list_set struct {
+	List  val_list
+	Index int
+	Value value
+}
+
+/** Records xs.pop(), reversed by pushing the removed element back on */
+type list_pop struct {
+	List  val_list
+	Value value
+}
+
+/** Records m[k] = v or delete(m, k), reversed by restoring the key exactly as it was */
+type map_entry struct {
+	Map     val_map
+	Key     value
+	Value   value
+	Existed bool
+}
+
+/** Records x := v, reversed by removing the binding or putting back the one it replaced */
+type declaration struct {
+	Value   value
+	Existed bool
+}
+
+/** Marks a savepoint of the skip block whose marker is Skip, reversing it does nothing */
+type savepoint struct {
+	Name   value
+	Skip   *BreadCrumb
+	Output int // length of the staged output at the savepoint
+}
+
+/** A native call with its arguments already evaluated */
+type native_call struct {
+	Fn   val_native_func
+	Args []value
+}
+
+/** Calls the native directly, without going through its inverse */
+func (nc native_call) run(twi *TWI) {
+	fn_scope := nc.Fn.scope(twi, nc.Args)
+	nc.Fn.Fn(twi, &fn_scope)
+}
+
+/** Left by a native with an inverse, reversed by calling Undo */
+type native_effect struct {
+	Do     *native_call // replaces the forward call when it must be made differently to be undone
+	Undo   native_call
+	Commit *native_call // cleans up once the call can no longer be reversed
+}
+
+/** An @undo call with the function and arguments it had when the annotated statement ran */
+type bound_call struct {
+	Call *ast.CallExpr
+	Fn   value
+	Args []value
+}
+
+/** Records call f(x) or uncall f(x) of a rev func in place of its updates, reversed by making the opposite call */
+type rev_call struct {
+	Call *ast.CallStmt
+}
+
 type BreadCrumb struct {
 	Prev       *BreadCrumb
 	SkipMarker *ast.SkipStmt
@@ -25,16 +103,130 @@ type BreadCrumb struct {
 	PrevVal    Reversible
 }
 
+/** The variable, list element or map entry a bread crumb restores */
+type location struct {
+	scope *Scope
+	name  string
+	data  any   // backing array of a list, or a map
+	key   value // index into a list or key of a map
+}
+
+/*
+*
+Reports whether bc snapshots a location that a bread crumb already restores to an
+earlier state, in which case bc can be left out of the chain. Only the first snapshot of
+each location since the last barrier is kept. Every bread crumb that is not a snapshot is
+a barrier, since skip markers, savepoints, compensations and rev calls can be reversed
+back to or depend on the state at the point they were left.
+*/
+func (twi *TWI) redundant(bc *BreadCrumb) bool {
+	if twi.FullUndoLog {
+		return false
+	}
+	var loc location
+	switch t := bc.PrevVal.(type) {
+	case list_set:
+		loc = location{data: t.List.Data, key: val_int{t.Index}}
+	case map_entry:
+		loc = location{data: reflect.ValueOf(t.Map.Data).UnsafePointer(), key: t.Key}
+	case list_push:
+		twi.forgetElements(t.List)
+		return false
+	case list_pop:
+		twi.forgetElements(t.List)
+		return false
+	case value, declaration:
+		loc = location{scope: bc.Scope, name: bc.Name}
+	default:
+		twi.snapshots = nil
+		return false
+	}
+	if twi.snapshots[loc] {
+		return true
+	}
+	if twi.snapshots == nil {
+		twi.snapshots = make(map[location]bool)
+	}
+	twi.snapshots[loc] = true
+	return false
+}
+
+/** Pushing or popping moves the end of a list, so its elements have to be snapshot again */
+func (twi *TWI) forgetElements(l val_list) {
+	for loc := range twi.snapshots {
+		if loc.data == any(l.Data) {
+			delete(twi.snapshots, loc)
+		}
+	}
+}
+
 func (bc BreadCrumb) Reverse(twi *TWI) {
 	switch t := bc.PrevVal.(type) {
-	case value:
-		switch v_type := t.(type) {
-		case val_index_val_pair:
-			bc.Scope.Get(bc.Name).(val_map).Data[v_type.Index] = v_type.Value
-		default:
-			bc.Scope.Set(bc.Name, t)
+	case list_push:
+		*t.List.Data = (*t.List.Data)[:len(*t.List.Data)-1]
+	case list_set:
+		(*t.List.Data)[t.Index] = t.Value
+	case list_pop:
+		*t.List.Data = append(*t.List.Data, t.Value)
+	case native_effect:
+		t.Undo.run(twi)
+	case rev_call:
+		prev_scope := twi.CurrentScope
+		twi.CurrentScope = bc.Scope
+		defer func() { twi.CurrentScope = prev_scope }()
+		twi.visitCallStmt(ast.Invert(t.Call).(*ast.CallStmt))
+	case map_entry:
+		if t.Existed {
+			t.Map.Data[t.Key] = t.Value
+		} else {
+			delete(t.Map.Data, t.Key)
+		}
+	case declaration:
+		if t.Existed {
+			bc.Scope.Values[bc.Name] = t.Value
+		} else {
+			delete(bc.Scope.Values, bc.Name)
 		}
+	case value:
+		bc.Scope.Set(bc.Name, t)
+	case bound_call:
+		twi.call(t.Fn, t.Args)
 	case ast.Statement:
+		// annotations other than calls run in the scope of the statement they annotate
+		prev_scope := twi.CurrentScope
+		twi.CurrentScope = bc.Scope
+		defer func() { twi.CurrentScope = prev_scope }()
 		twi.executeStmt(t)
 	}
 }
+
+/** Describes the compensation reversing bc runs, or "" if it only restores interpreter state */
+func (bc BreadCrumb) compensation() string {
+	switch t := bc.PrevVal.(type) {
+	case bound_call:
+		name := ""
+		switch fn := t.Fn.(type) {
+		case val_func:
+			name = fn.Name
+		case val_native_func:
+			name = fn.Name
+		}
+		return describeCall(name, t.Args)
+	case native_effect:
+		return describeCall(t.Undo.Fn.Name, t.Undo.Args)
+	case rev_call:
+		inverse := ast.Invert(t.Call).(*ast.CallStmt)
+		return fmt.Sprintf("%v %v", inverse.Token.Kind, inverse.Call.Callee.ExprStr())
+	case ast.Statement:
+		return fmt.Sprintf("@undo at %v", ast.Pos(t))
+	}
+	return ""
+}
+
+func describeCall(name string, args []value) string {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = arg.ToString()
+	}
+	return fmt.Sprintf("%v(%v)", name, strings.Join(strs, ", "))
+}
@@ -0,0 +1,184 @@
+package interpreter
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pcen/ape/ape/ast"
+)
+
+/*
+*
+The tasks of a program run on goroutines of their own, but take turns running
+interpreter code: a task holds the lock while it runs and only lets go of it while it
+waits, on a channel, for another task or for a request. The global scope, the values
+tasks share and the natives' state are only ever touched by the task holding the lock.
+*/
+type taskGroup struct {
+	lock sync.Mutex
+	wg   sync.WaitGroup // tasks that have not finished
+	next int            // id of the next task spawned
+
+	versions map[string]int          // times each global was written, once a transaction has run
+	owners   map[string]*transaction // transactions that wrote to a global and have not ended
+}
+
+/** A call running as a task, the result and err of which are set before done is closed */
+type task struct {
+	id     int
+	name   string
+	done   chan struct{}
+	result value
+	err    error
+}
+
+type val_task struct {
+	t *task
+}
+
+func (v val_task) Equals(other value) bool {
+	o, ok := other.(val_task)
+	return ok && v.t == o.t
+}
+
+func (v val_task) ToString() string {
+	return fmt.Sprintf("TASK %v %v", v.t.id, v.t.name)
+}
+
+type val_chan struct {
+	ch chan value
+}
+
+func (v val_chan) Equals(other value) bool {
+	o, ok := other.(val_chan)
+	return ok && v.ch == o.ch
+}
+
+func (v val_chan) ToString() string {
+	return fmt.Sprintf("CHAN %v/%v", len(v.ch), cap(v.ch))
+}
+
+/** Lets the other tasks run while this one waits */
+func (twi *TWI) blocking(wait func()) {
+	twi.tasks.lock.Unlock()
+	defer twi.tasks.lock.Lock()
+	wait()
+}
+
+/*
+*
+Starts a call on a task of its own. The task gets its own bread crumb chain, scopes and
+journal, so a reverse in it only unwinds its own skip blocks, and a task spawned inside a
+skip block is not reversed with it. What the task shares with its spawner is the global
+scope and the values passed to it.
+*/
+func (twi *TWI) visitSpawnExpr(e *ast.SpawnExpr) value {
+	callee := twi.evaluateExpr(e.Call.Callee)
+	args := make([]value, 0, len(e.Call.Args))
+	for _, arg := range e.Call.Args {
+		args = append(args, twi.evaluateExpr(arg))
+	}
+
+	twi.tasks.next++
+	t := &task{id: twi.tasks.next, name: ast.Pos(e.Call).String(), done: make(chan struct{})}
+	if fn, ok := callee.(val_func); ok {
+		t.name = fn.Name
+	}
+	child := &TWI{
+		GlobalScope:  twi.GlobalScope,
+		CurrentScope: twi.GlobalScope,
+		Stdout:       twi.Stdout,
+		BufferOutput: twi.BufferOutput,
+		FullUndoLog:  twi.FullUndoLog,
+		Reverted:     twi.Reverted,
+		natives:      twi.natives,
+		stores:       twi.stores,
+		tasks:        twi.tasks,
+	}
+	if twi.Journal != nil {
+		j, err := OpenJournal(taskJournalPath(twi.Journal.path, t.id))
+		if err != nil {
+			panic(err)
+		}
+		child.Journal = j
+	}
+
+	twi.tasks.wg.Add(1)
+	go func() {
+		defer twi.tasks.wg.Done()
+		twi.tasks.lock.Lock()
+		defer twi.tasks.lock.Unlock()
+		t.result, t.err = child.run(func() value { return child.call(callee, args) })
+		if child.Journal != nil {
+			child.Journal.Remove()
+		}
+		close(t.done)
+	}()
+	return val_task{t}
+}
+
+/** Journals of tasks live next to the journal of the program that spawned them */
+func taskJournalPath(path string, id int) string {
+	return fmt.Sprintf("%v.task%v", path, id)
+}
+
+/** The journal at path followed by the journals of the tasks its program spawned, most recent last */
+func journalFiles(path string) ([]string, error) {
+	tasks, err := filepath.Glob(path + ".task*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		// task ids have no leading zeros, so shorter paths belong to earlier tasks
+		if len(tasks[i]) != len(tasks[j]) {
+			return len(tasks[i]) < len(tasks[j])
+		}
+		return tasks[i] < tasks[j]
+	})
+	return append([]string{path}, tasks...), nil
+}
+
+/** Natives for tasks and the channels they talk over */
+var TASK_NATIVES = []val_native_func{
+	{
+		// a task that failed fails the join, as a runtime error the joiner can seize
+		Name:   "join",
+		Params: []string{"task"},
+		Fn: func(twi *TWI, scope *Scope) {
+			t := scope.Get("task").(val_task).t
+			twi.blocking(func() { <-t.done })
+			if t.err != nil {
+				panic(fmt.Sprintf("task %v failed: %v", t.name, t.err))
+			}
+			panic(ReturnHolder{Value: t.result})
+		},
+	},
+	{
+		// channels with a capacity of 0 hand each message straight to a receiver
+		Name:   "channel",
+		Params: []string{"capacity"},
+		Fn: func(twi *TWI, scope *Scope) {
+			panic(ReturnHolder{Value: val_chan{make(chan value, scope.Get("capacity").(val_int).Value)}})
+		},
+	},
+	{
+		Name:   "send",
+		Params: []string{"channel", "value"},
+		Fn: func(twi *TWI, scope *Scope) {
+			ch, val := scope.Get("channel").(val_chan).ch, scope.Get("value")
+			twi.blocking(func() { ch <- val })
+		},
+	},
+	{
+		Name:   "recv",
+		Params: []string{"channel"},
+		Fn: func(twi *TWI, scope *Scope) {
+			ch := scope.Get("channel").(val_chan).ch
+			var val value
+			twi.blocking(func() { val = <-ch })
+			panic(ReturnHolder{Value: val})
+		},
+	},
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"strconv"
+	"strings"
 
 	"github.com/pcen/ape/ape/ast"
 )
@@ -30,27 +31,23 @@ func (v val_void) ToString() string {
 	return "VOID"
 }
 
-/** Needed to easily support breadcrumb reversal for maps */
-type val_index_val_pair struct {
-	Index value
-	Value value
-}
-
-func (vivp val_index_val_pair) Equals(other value) bool {
-	return false
-}
-
-func (vivp val_index_val_pair) ToString() string {
-	return vivp.Index.ToString() + ": " + vivp.Value.ToString()
-}
-
 type val_native_func struct {
-	Name     string
-	Params   []string
-	Fn       func(*Scope)
+	Name   string
+	Params []string
+	Fn     func(*TWI, *Scope)
+	// Inverse is called before Fn inside a skip block, and returns how to undo the call
+	// (snapshotting whatever that needs), or nil if there is nothing to undo
+	Inverse  func(*TWI, *Scope) *native_effect
 	Variadic bool
 }
 
+func (vnf val_native_func) scope(twi *TWI, args []value) Scope {
+	if vnf.Variadic {
+		return MakeVariadicFnScope(twi.GlobalScope, args)
+	}
+	return MakeFnScope(twi.GlobalScope, args, vnf.Params)
+}
+
 // Pointless
 func (vnf val_native_func) Equals(other value) bool {
 	return false
@@ -62,9 +59,10 @@ func (vnf val_native_func) ToString() string {
 
 /** Internal representation of a function. First class citizen */
 type val_func struct {
-	Name   string
-	Params []string
-	Body   *ast.BlockStmt
+	Name    string
+	Params  []string
+	Body    *ast.BlockStmt
+	Inverse *ast.BlockStmt // body of the uncall of a rev func, nil for other functions
 }
 
 func (fn val_func) Equals(other value) bool {
@@ -80,11 +78,51 @@ func (v val_func) ToString() string {
 	return "FUNC: " + v.Name
 }
 
-//TODO: List
-// type val_list[T value] struct {
-// 	Size int
-// 	Data []T
-// }
+/** Lists hold a pointer to their elements so that every reference sees push and pop */
+type val_list struct {
+	Data *[]value
+}
+
+func newList(elements []value) val_list {
+	return val_list{Data: &elements}
+}
+
+func (l val_list) Equals(other value) bool {
+	switch t := other.(type) {
+	case val_list:
+		if len(*l.Data) != len(*t.Data) {
+			return false
+		}
+		for i, v := range *l.Data {
+			if !v.Equals((*t.Data)[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func (l val_list) ToString() string {
+	elements := make([]string, 0, len(*l.Data))
+	for _, v := range *l.Data {
+		elements = append(elements, v.ToString())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+/** Negative indices count from the end of the list, the same as the c vectors */
+func (l val_list) offset(index value) int {
+	i := index.(val_int).Value
+	if i < 0 {
+		i += len(*l.Data)
+	}
+	if i < 0 || i >= len(*l.Data) {
+		panic(fmt.Sprintf("Index %d out of range for list of length %d", index.(val_int).Value, len(*l.Data)))
+	}
+	return i
+}
 
 type val_map struct {
 	Data map[value]value
@@ -117,7 +155,94 @@ func (m val_map) ToString() string {
 	for k, v := range m.Data {
 		out += k.ToString() + ": " + v.ToString() + ", "
 	}
-	return out[:len(out)-2]
+	return strings.TrimSuffix(out, ", ")
+}
+
+/** A class, which constructs an object when called with a value for each member in order */
+type val_class struct {
+	Name    string
+	Members []string
+}
+
+func (c val_class) Equals(other value) bool {
+	switch t := other.(type) {
+	case val_class:
+		return c.Name == t.Name
+	default:
+		return false
+	}
+}
+
+func (c val_class) ToString() string {
+	return "CLASS: " + c.Name
+}
+
+func (c val_class) construct(args []value) val_object {
+	if len(args) != len(c.Members) {
+		panic(fmt.Sprintf("%s takes %d values, got %d", c.Name, len(c.Members), len(args)))
+	}
+	obj := val_object{Class: c, Fields: make(map[string]value, len(args))}
+	for i, member := range c.Members {
+		obj.Fields[member] = args[i]
+	}
+	return obj
+}
+
+/** Objects hold a map of their fields so that every reference sees the same object */
+type val_object struct {
+	Class  val_class
+	Fields map[string]value
+}
+
+func (o val_object) Equals(other value) bool {
+	t, ok := other.(val_object)
+	if !ok || !o.Class.Equals(t.Class) {
+		return false
+	}
+	for name, v := range o.Fields {
+		if !v.Equals(t.Fields[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (o val_object) ToString() string {
+	fields := make([]string, 0, len(o.Class.Members))
+	for _, member := range o.Class.Members {
+		fields = append(fields, member+": "+o.Fields[member].ToString())
+	}
+	return o.Class.Name + "{" + strings.Join(fields, ", ") + "}"
+}
+
+/** True when v has the type t names, used to match reversed values against typed seizes */
+func isType(v value, t *ast.TypeExpr) bool {
+	if t.List {
+		l, ok := v.(val_list)
+		if !ok {
+			return false
+		}
+		for _, el := range *l.Data {
+			if !isType(el, &ast.TypeExpr{Name: t.Name}) {
+				return false
+			}
+		}
+		return true
+	}
+	switch tv := v.(type) {
+	case val_str:
+		return t.Name == "string"
+	case val_int:
+		return t.Name == "int"
+	case val_rational:
+		return t.Name == "float"
+	case val_bool:
+		return t.Name == "bool"
+	case val_object:
+		return t.Name == tv.Class.Name
+	default:
+		return false
+	}
 }
 
 type val_bool struct {
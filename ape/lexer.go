@@ -35,6 +35,8 @@ var (
 		token.CloseBrace:  true,
 		token.CloseBrack:  true,
 		token.Reverse:     true,
+		token.Retry:       true,
+		token.Commit:      true,
 	}
 )
 
@@ -322,7 +324,10 @@ func (l *lexer) step() token.Token {
 		if l.match('<') {
 			return l.NewToken(token.ShiftLeft)
 		}
-		return l.pick('=', token.LessEq, token.Less)
+		if l.match('=') {
+			return l.pick('>', token.Swap, token.LessEq)
+		}
+		return l.NewToken(token.Less)
 
 	case '>':
 		if l.match('>') {
@@ -340,7 +345,7 @@ func (l *lexer) step() token.Token {
 		return l.NewToken(token.Tilde)
 
 	case '^':
-		return l.NewToken(token.Caret)
+		return l.pick('=', token.CaretEq, token.Caret)
 
 	case '.':
 		return l.NewToken(token.Dot)
@@ -23,6 +23,7 @@ var (
 	declStart = map[token.Kind]bool{
 		token.Identifier: true,
 		token.Func:       true,
+		token.Rev:        true,
 	}
 
 	stmtStart = map[token.Kind]bool{
@@ -61,6 +62,7 @@ type parser struct {
 	pos    int
 	errors []ParseError
 	decls  []ast.Declaration
+	skips  []*ast.SkipStmt // skip a retry statement belongs to, innermost last, nil in a skip body
 }
 
 func NewParser(tokens []token.Token) Parser {
@@ -220,6 +222,14 @@ func (p *parser) Unary() ast.Expression {
 	switch p.peek().Kind {
 	case token.Bang, token.Minus, token.Tilde:
 		return ast.NewUnaryOp(p.next().Kind, p.Unary())
+	case token.Spawn:
+		e := &ast.SpawnExpr{Token: p.next()}
+		call, ok := p.Primary().(*ast.CallExpr)
+		if !ok {
+			p.err("spawn must be followed by a function call")
+		}
+		e.Call = call
+		return e
 	default:
 		return p.Power()
 	}
@@ -347,7 +357,7 @@ func (p *parser) Statement() (s ast.Statement) {
 	//   literals. we could prevent literals here, which would be simple to parse but would
 	//   technically complicate the grammar
 	case token.Identifier, token.True, token.False, token.Integer, token.Rational, token.String, token.OpenParen, token.OpenBrack, // atom
-		token.Bang, token.Minus, token.Tilde, token.Reverse: // unary operators
+		token.Bang, token.Minus, token.Tilde, token.Spawn, token.Reverse, token.Savepoint: // unary operators
 		s = p.SimpleStmt(true)
 		p.separator("simple stmt")
 
@@ -391,10 +401,22 @@ func (p *parser) Statement() (s ast.Statement) {
 		s = &ast.ErrStmt{}
 		panic("stmt at eof")
 
-	case token.Skip:
+	case token.Skip, token.Atomic:
 		s = p.SkipStmt()
 		p.separator("skip stmt")
 
+	case token.Retry:
+		s = p.RetryStmt()
+		p.separator("retry stmt")
+
+	case token.Commit:
+		s = &ast.CommitStmt{Token: p.next()}
+		p.separator("commit stmt")
+
+	case token.Call, token.Uncall:
+		s = p.CallStmt()
+		p.separator("call stmt")
+
 	default:
 		// ast.PrettyPrint(p.decls)
 		for _, err := range p.errors {
@@ -413,9 +435,14 @@ func (p *parser) SimpleStmt(annotateable bool) ast.Statement {
 		return p.ReverseStmt()
 	}
 
+	// savepoint
+	if p.peekIs(token.Savepoint) {
+		return p.SavepointStmt()
+	}
+
 	// declaration
 	if p.peekIs(token.Identifier) && p.peekn(2).Kind == token.Colon {
-		return &ast.TypedDeclStmt{Decl: p.VarDecl()}
+		return &ast.TypedDeclStmt{Decl: p.VarDecl(), Annotations: p.annotations(annotateable)}
 	}
 
 	// increment / decrement
@@ -428,11 +455,20 @@ func (p *parser) SimpleStmt(annotateable bool) ast.Statement {
 	}
 
 	// assignment
-	if p.match(token.Assign, token.PlusEq, token.MinusEq, token.StarEq, token.DivideEq, token.PowerEq, token.ModEq) {
+	if p.match(token.Assign, token.PlusEq, token.MinusEq, token.StarEq, token.DivideEq, token.PowerEq, token.ModEq, token.CaretEq) {
 		return ast.NewAssignmentStmt(lhs, p.prev(), p.Expression())
 	}
 
+	// swap
+	if p.match(token.Swap) {
+		return &ast.SwapStmt{Token: p.prev(), Lhs: lhs, Rhs: p.Expression()}
+	}
+
 	// expression
+	return &ast.ExprStmt{Expr: lhs, Annotations: p.annotations(annotateable)}
+}
+
+func (p *parser) annotations(annotateable bool) map[string]ast.Statement {
 	annotations := make(map[string]ast.Statement)
 	for p.match(token.At) {
 		if !annotateable {
@@ -443,7 +479,7 @@ func (p *parser) SimpleStmt(annotateable bool) ast.Statement {
 		}
 		annotations[p.prev().Lexeme] = p.SimpleStmt(false)
 	}
-	return &ast.ExprStmt{Expr: lhs, Annotations: annotations}
+	return annotations
 }
 
 func (p *parser) ReturnStmt() *ast.ReturnStmt {
@@ -507,9 +543,22 @@ func (p *parser) IfStmt() *ast.IfStmt {
 	if p.match(token.Else) {
 		stmt.Else = p.BlockStmt()
 	}
+	if p.match(token.Fi) {
+		stmt.Fi = p.Expression()
+	}
 	return stmt
 }
 
+func (p *parser) CallStmt() *ast.CallStmt {
+	s := &ast.CallStmt{Token: p.next()}
+	call, ok := p.Expression().(*ast.CallExpr)
+	if !ok {
+		p.err("%v must be followed by a function call", s.Token.Kind)
+	}
+	s.Call = call
+	return s
+}
+
 func (p *parser) CondBlockStmt() *ast.CondBlockStmt {
 	if p.peek().Kind == token.OpenBrace {
 		p.err("missing predicate expression for conditional block")
@@ -544,27 +593,86 @@ func (p *parser) ForStmt() *ast.ForStmt {
 func (p *parser) ReverseStmt() *ast.ReverseStmt {
 	s := &ast.ReverseStmt{}
 	p.consume(token.Reverse, "reverse stmt")
+	s.Token = p.prev()
+	// to is only a keyword here, where it is followed by the name of a savepoint, so that
+	// it can still name a variable anywhere else
+	if to := p.peek(); to.Kind == token.Identifier && to.Lexeme == "to" && (p.peekn(2).Kind == token.String || p.peekn(2).Kind == token.Identifier) {
+		p.next()
+		s.To = p.Expression()
+		return s
+	}
 	if p.peek().Kind != token.Sep && p.peek().Kind != token.OpenBrace {
 		s.Expr = p.Expression()
 	}
 	return s
 }
 
+func (p *parser) SavepointStmt() *ast.SavepointStmt {
+	s := &ast.SavepointStmt{}
+	p.consume(token.Savepoint, "savepoint stmt")
+	s.Token = p.prev()
+	s.Name = p.Expression()
+	return s
+}
+
 func (p *parser) SkipStmt() *ast.SkipStmt {
 	s := &ast.SkipStmt{}
+	if p.match(token.Atomic) {
+		atomic := p.prev()
+		s.Atomic = &atomic
+	}
 	p.consume(token.Skip, "skip stmt")
+	s.Token = p.prev()
+
+	// a retry in the skip body belongs to no skip, a retry in a seize belongs to this one
+	defer func(skips []*ast.SkipStmt) { p.skips = skips }(p.skips)
+	p.skips = append(p.skips, nil)
 	s.Body = p.BlockStmt()
+	p.skips[len(p.skips)-1] = s
+
 	s.Seizes = make([]*ast.SeizeStmt, 0)
 	for p.peekIs(token.Seize) {
 		s.Seizes = append(s.Seizes, p.SeizeStmt())
 	}
+	if p.match(token.Always) {
+		// the always clause runs after the seizes are done, so it cannot retry
+		p.skips[len(p.skips)-1] = nil
+		s.Always = p.BlockStmt()
+	}
+	return s
+}
+
+func (p *parser) RetryStmt() *ast.RetryStmt {
+	s := &ast.RetryStmt{}
+	p.consume(token.Retry, "retry stmt")
+	s.Token = p.prev()
+	if len(p.skips) == 0 || p.skips[len(p.skips)-1] == nil {
+		// the statement itself is fine, so there is nothing to recover from
+		p.errors = append(p.errors, NewParseError(s.Token.Position, "retry outside of a seize"))
+	} else {
+		skip := p.skips[len(p.skips)-1]
+		skip.Retries = append(skip.Retries, s)
+	}
+
+	if p.peekIs(token.Identifier) && p.peekn(2).Kind == token.Colon {
+		s.Counter = &ast.IdentExpr{Ident: p.next()}
+		p.next()
+		s.Max = p.Expression()
+	} else if !p.peekIs(token.Sep, token.CloseBrace) {
+		s.Max = p.Expression()
+	}
 	return s
 }
 
 func (p *parser) SeizeStmt() *ast.SeizeStmt {
 	s := &ast.SeizeStmt{}
 	p.consume(token.Seize, "seize stmt")
-	if !p.peekIs(token.OpenBrace) {
+	s.Token = p.prev()
+	if p.peekIs(token.Identifier) && p.peekn(2).Kind == token.Colon {
+		s.Name = &ast.IdentExpr{Ident: p.next()}
+		p.next()
+		s.Type = p.Type()
+	} else if !p.peekIs(token.OpenBrace) {
 		s.Expr = p.Expression()
 	}
 	s.Body = p.BlockStmt()
@@ -595,7 +703,7 @@ func (p *parser) Declaration() (d ast.Declaration) {
 
 	switch kind := p.peek().Kind; kind {
 
-	case token.Func:
+	case token.Func, token.Rev:
 		d = p.FuncDecl()
 		p.separator("end of func decl")
 
@@ -659,7 +767,7 @@ func (p *parser) VarDecl() *ast.VarDecl {
 }
 
 func (p *parser) FuncDecl() *ast.FuncDecl {
-	fd := &ast.FuncDecl{}
+	fd := &ast.FuncDecl{Rev: p.match(token.Rev)}
 	p.consume(token.Func, "function declaration start")
 
 	p.consume(token.Identifier, "function name")
@@ -716,6 +824,14 @@ func (p *parser) MemberDecl() *ast.MemberDecl {
 // Miscellaneous
 
 func (p *parser) Type() *ast.TypeExpr {
+	if p.match(token.Chan) {
+		t := p.Type()
+		if t.Chan {
+			p.err("channels cannot carry channels")
+		}
+		t.Chan = true
+		return t
+	}
 	list := false
 	if p.match(token.OpenBrack) && p.match(token.CloseBrack) {
 		list = true
@@ -9,8 +9,8 @@ import (
 
 const (
 	prog = `{
-	foo() @reverse bar()
-	login() @reverse logout()
+	foo() @undo bar()
+	login() @undo logout()
 }`
 )
 
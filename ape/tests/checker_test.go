@@ -2,6 +2,7 @@ package tests
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/pcen/ape/ape/ast"
@@ -48,17 +49,17 @@ const (
 	prog4 = `
 		module test
 		func main() {
-			SKIP {
-				REVERSE 1
-			} SEIZE {
+			skip {
+				reverse 1
+			} seize {
 				println("default seize")
 			}
 		}
 
 		func notMain() {
-			SKIP {
-				REVERSE "string"
-			} SEIZE ("string") {
+			skip {
+				reverse "string"
+			} seize ("string") {
 				println("seize on a string")
 			}
 		}
@@ -67,13 +68,220 @@ const (
 	badSeize = `
 		module test
 		func main() {
-			SKIP {
-				REVERSE 1.0
-			} SEIZE (1) {
+			skip {
+				reverse 1.0
+			} seize (1) {
 				println("bad")
 			}
 		}
 	`
+
+	badSavepoints = `
+		module test
+		func main() {
+			savepoint "outside"
+			skip {
+				savepoint 1
+				reverse to "inside"
+			}
+			reverse to "outside"
+		}
+	`
+
+	unseizedReverses = `
+		module test
+		class Failure {
+			code int
+			message string
+		}
+
+		func main() {
+			skip {
+				reverse "seized"
+			} seize "seized" {
+			}
+			skip {
+				reverse Failure(1, "failed")
+				reverse "unseized"
+				reverse 2
+			} seize f: Failure {
+				println(f.message)
+				println(f.missing)
+			} seize "seized" {
+			} seize n: int {
+				reverse n
+			}
+			skip {
+				skip {
+					reverse 1.5
+				} seize 1 {
+				}
+			} seize {
+			}
+		}
+	`
+
+	calledReverses = `
+		module test
+		func charge(n int) {
+			if n > 10 {
+				reverse 42
+			}
+			refund(n - 1)
+		}
+
+		func refund(n int) {
+			if n > 0 {
+				charge(n)
+			}
+		}
+
+		func book() {
+			skip {
+				charge(5)
+			} seize code: int {
+				println(code)
+			}
+		}
+
+		func main() {
+			book()
+			skip {
+				refund(3)
+			} seize "X" {
+			}
+			charge(1)
+		}
+	`
+
+	irreversibleCalls = `
+		module test
+		func log(msg string) {
+			println(msg)
+		}
+
+		func audit(msg string) {
+			log(msg)
+		}
+
+		func save(data string) {
+			write("saved", data)
+		}
+
+		func main() {
+			skip {
+				audit("booked")
+				save("booked")
+				shell("rm booked") @undo shell("touch booked")
+				println("booked")
+			} seize {
+			}
+			println("done")
+		}
+	`
+
+	badRevFuncs = `
+		module test
+		rev func bad(x int, y int) int {
+			x = 1
+			x -= x + y
+			if x > 0 {
+				y += 1
+			}
+			println("bad")
+		}
+
+		rev func fine(x int, y int) {
+			if x > 0 {
+				y += x
+			} else {
+				x <=> y
+			} fi y > 0
+		}
+
+		func plain(x int) {
+		}
+
+		func main() {
+			a := 1
+			b := 2
+			call fine(a, b)
+			uncall fine(a, b)
+			call plain(a)
+			call fine(a, a)
+			uncall fine(1, a)
+		}
+	`
+
+	commits = `
+		module test
+		func main() {
+			commit
+			skip {
+				touch("a") @undo delete("a")
+				skip {
+					touch("b") @undo delete("b")
+				}
+				commit
+				commit
+				touch("c") @undo delete("c")
+			} seize {
+				commit
+			}
+		}
+	`
+
+	badCompensations = `
+		module test
+		func create() int {
+			println("created")
+			return 1
+		}
+
+		func destroy(id int) {
+			println("destroyed")
+		}
+
+		func main() {
+			skip {
+				id := create() @undo destroy(it)
+				create() @undo destroy(it)
+				println("done") @undo destroy(it)
+				destroy(id)
+			} seize {
+			}
+		}
+	`
+
+	sagaCalls = `
+		module test
+		func main() {
+			skip {
+				order := http_post("http://shop/orders", "{}") @undo http_delete("http://shop/orders/" + it)
+				http_get("http://shop/orders/" + order)
+				http_put("http://shop/orders/" + order, "{}")
+			} seize {
+			}
+		}
+	`
+
+	spawns = `
+		module test
+		func work(n int) int {
+			return n * 2
+		}
+		func main() {
+			out: chan int = channel(1)
+			t := spawn work(2)
+			send(out, join(t))
+			send(out, "two")
+			skip {
+				spawn work(3)
+			} seize {
+			}
+			total: int = recv(out)
+		}
+	`
 )
 
 var (
@@ -102,3 +310,139 @@ func TestChecker(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckSavepoints(t *testing.T) {
+	f, _ := Parse(badSavepoints)
+	c := types.NewChecker(f)
+	c.Check()
+	if len(c.Errors) != 3 {
+		t.Errorf("expected 3 checker errors, got %v: %v", len(c.Errors), c.Errors)
+	}
+}
+
+func TestCheckReverseTypes(t *testing.T) {
+	f, _ := Parse(unseizedReverses)
+	c := types.NewChecker(f)
+	c.Check()
+	// the string reverse is never seized, the int reversed again from its seize escapes main,
+	// seize 1 matches no reverse and Failure has no missing member. The reverse of "seized"
+	// is handled by the seize of that value, so it does not escape
+	want := []string{
+		"19:21: Failure has no member missing",
+		"27:11: seize type int does not match any reverse type in skip block",
+		"22:11: reverse of type int can execute outside any skip",
+		"15:11: reverse of type string can execute outside any skip",
+	}
+	if len(c.Errors) != len(want) {
+		t.Fatalf("expected %v checker errors, got %v: %v", len(want), len(c.Errors), c.Errors)
+	}
+	for i, err := range c.Errors {
+		if got := fmt.Sprint(&err); got != want[i] {
+			t.Errorf("expected checker error %q, got %q", want[i], got)
+		}
+	}
+}
+
+func TestCheckCalledReverses(t *testing.T) {
+	f, _ := Parse(calledReverses)
+	c := types.NewChecker(f)
+	c.Check()
+	// seize "X" cannot match the int reversed by charge, which reaches main through refund
+	// from the skip and from the call outside of any skip, reported once at the reverse
+	if len(c.Errors) != 2 {
+		t.Errorf("expected 2 checker errors, got %v: %v", len(c.Errors), c.Errors)
+	}
+}
+
+func TestIrreversibleEffects(t *testing.T) {
+	f, _ := Parse(irreversibleCalls)
+	c := types.NewChecker(f)
+	c.Check()
+	if len(c.Errors) != 0 || len(c.Warnings) != 2 {
+		t.Fatalf("expected 2 warnings and no errors, got %v and %v", c.Warnings, c.Errors)
+	}
+	for name, want := range map[string]types.Effect{"log": types.Irreversible, "audit": types.Irreversible, "save": types.Reversible, "read": types.Pure} {
+		if got := c.EffectOf(name); got != want {
+			t.Errorf("effect of %v: expected %v, got %v", name, want, got)
+		}
+	}
+	if path := fmt.Sprint(c.Warnings[0]); !strings.Contains(path, "skip (16:7) -> audit (17:9) -> log (8:6) -> println (4:10)") {
+		t.Errorf("expected the call path from the skip to println, got %v", path)
+	}
+
+	strict := types.NewChecker(f)
+	strict.Strict = true
+	strict.Check()
+	if len(strict.Errors) != 2 {
+		t.Errorf("expected the warnings to be errors in strict mode, got %v", strict.Errors)
+	}
+}
+
+func TestCheckCommits(t *testing.T) {
+	f, _ := Parse(commits)
+	c := types.NewChecker(f)
+	c.Check()
+	// main and the seize are not in a skip, and the first commit in the skip makes both
+	// earlier undos irrevocable while the second has nothing left to commit
+	if len(c.Errors) != 2 || len(c.Warnings) != 2 {
+		t.Errorf("expected 2 errors and 2 warnings, got %v and %v", c.Errors, c.Warnings)
+	}
+}
+
+func TestCheckRevFuncs(t *testing.T) {
+	f, _ := Parse(badRevFuncs)
+	c := types.NewChecker(f)
+	c.Check()
+	// bad returns a value, assigns with =, updates x from itself, has an if without a fi
+	// and calls println, which is not pure. main calls a func that is not rev, passes a
+	// twice and passes a literal. Each is reported once, where it happens
+	want := []string{
+		"3:14: rev func bad cannot return a value",
+		"4:6: = is not reversible in rev func bad, only +=, -= and ^= are",
+		"5:7: x cannot be updated with an expression that uses x",
+		"6:7: if (> x 0) in rev func bad needs a fi assertion",
+		"9:10: rev func bad must be pure, but it calls println (9:10)",
+		"28:7: call of plain, which is not a rev func",
+		"29:7: a is passed to call more than once",
+		"30:9: argument 1 of uncall must be a variable",
+	}
+	if len(c.Errors) != len(want) {
+		t.Fatalf("expected %v checker errors, got %v: %v", len(want), len(c.Errors), c.Errors)
+	}
+	for i, err := range c.Errors {
+		if got := fmt.Sprint(&err); got != want[i] {
+			t.Errorf("expected checker error %q, got %q", want[i], got)
+		}
+	}
+}
+
+func TestCheckBoundCompensations(t *testing.T) {
+	f, _ := Parse(badCompensations)
+	c := types.NewChecker(f)
+	c.Check()
+	// it is the int create returns, println returns nothing, and only destroy(id) has no @undo
+	if len(c.Errors) != 1 || len(c.Warnings) != 1 {
+		t.Errorf("expected 1 error and 1 warning, got %v and %v", c.Errors, c.Warnings)
+	}
+}
+
+func TestCheckHTTPCalls(t *testing.T) {
+	f, _ := Parse(sagaCalls)
+	c := types.NewChecker(f)
+	c.Check()
+	// gets change nothing, the put has no compensating request
+	if len(c.Errors) != 0 || len(c.Warnings) != 1 {
+		t.Errorf("expected no errors and 1 warning, got %v and %v", c.Errors, c.Warnings)
+	}
+}
+
+func TestCheckSpawns(t *testing.T) {
+	f, _ := Parse(spawns)
+	c := types.NewChecker(f)
+	c.Check()
+	// join and recv take the types of their task and channel, the task spawned in the
+	// skip block is not reversed with it
+	if len(c.Errors) != 1 || len(c.Warnings) != 1 {
+		t.Errorf("expected 1 error and 1 warning, got %v and %v", c.Errors, c.Warnings)
+	}
+}
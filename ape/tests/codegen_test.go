@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/pcen/ape/ape"
+	"github.com/pcen/ape/ape/c"
+	"github.com/pcen/ape/ape/interpreter"
+	"github.com/pcen/ape/ape/types"
+)
+
+// compile generates c for the ape program at path and builds it with gcc, returning the binary
+func compile(t *testing.T, path string) string {
+	f := ape.NewParser(ape.NewLexer().LexFile(path)).File()
+	checker := types.NewChecker(f)
+	env := checker.Check()
+	if len(checker.Errors) > 0 {
+		t.Fatalf("%v does not type check: %v", path, checker.Errors)
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.c")
+	if err := os.WriteFile(src, []byte(c.GenerateCode(f.Ast, env).Code.String()), 0664); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(dir, "main")
+	if out, err := exec.Command("gcc", src, "-lm", "-o", bin).CombinedOutput(); err != nil {
+		t.Fatalf("gcc: %v\n%s", err, out)
+	}
+	return bin
+}
+
+// The programs in tests/interpreter cannot be compiled as they are: they have no module
+// declaration, several do not type check (misspelled types, main returning 0 without a
+// return type), and others use maps or string concatenation, which the c backend does
+// not generate. The programs in tests cover the same skip, seize
+// and reverse behaviour in a form the whole pipeline accepts.
+func TestCodegenReversals(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc is not installed")
+	}
+	for _, name := range []string{"skip_seize.ape", "reverse_annotation.ape", "airplane.ape", "always.ape"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join("../../tests", name)
+			got, err := exec.Command(compile(t, path)).Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var want bytes.Buffer
+			twi := interpreter.NewTWI()
+			twi.Stdout = &want
+			for _, decl := range ape.NewParser(ape.NewLexer().LexFile(path)).File().Ast {
+				twi.Interpret(decl)
+			}
+			if err := twi.RunMain(); err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want.String() {
+				t.Errorf("expected the output of the interpreter:\n%v\ngot:\n%s", want.String(), got)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pcen/ape/ape/interpreter"
+)
+
+const (
+	// charge and refund are natives of the host
+	checkout = `
+	func total(prices []int) int {
+		sum := 0
+		for i := 0; i < prices.len(); i++ {
+			sum += prices[i]
+		}
+		return sum
+	}
+
+	func pay(account string, prices []int) string {
+		skip {
+			for i := 0; i < prices.len(); i++ {
+				charge(account, prices[i])
+			}
+		} seize err: RuntimeError {
+			return err.message
+		}
+		println("paid ", total(prices))
+		return "paid"
+	}
+
+	func byItems() {
+		return {[1, 2]: 3}
+	}
+
+	func unpaid(account string) {
+		skip {
+			charge(account, 5)
+			reverse "CANCELLED"
+		}
+	}
+	`
+)
+
+func TestEmbedding(t *testing.T) {
+	balances := map[string]int{"alex": 100}
+	var charges []string
+	charge := func(account string, amount int) (int, error) {
+		if amount > balances[account] {
+			return 0, errors.New("insufficient funds")
+		}
+		charges = append(charges, fmt.Sprint("charge ", amount))
+		balances[account] -= amount
+		return balances[account], nil
+	}
+	refund := func(account string, amount int) {
+		charges = append(charges, fmt.Sprint("refund ", amount))
+		balances[account] += amount
+	}
+
+	e := interpreter.NewEngine()
+	var out bytes.Buffer
+	e.SetOutput(&out)
+	if err := e.Register("charge", charge, refund); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Load(checkout); err != nil {
+		t.Fatal(err)
+	}
+
+	if total, err := interpreter.CallAs[int](e, "total", []int{1, 2, 3}); err != nil || total != 6 {
+		t.Errorf("expected total 6, got %v and %v", total, err)
+	}
+	if result, err := e.Call("pay", "alex", []int{30, 50}); err != nil || result != "paid" {
+		t.Errorf("expected the first payment to succeed, got %v and %v", result, err)
+	}
+	// the host's error reverses the skip block, which refunds the first charge
+	if result, err := e.Call("pay", "alex", []int{10, 40}); err != nil || !strings.Contains(result.(string), "insufficient funds") {
+		t.Errorf("expected the second payment to fail, got %v and %v", result, err)
+	}
+	if balances["alex"] != 20 || out.String() != "paid 80\n" {
+		t.Errorf("expected a balance of 20 and one payment printed, got %v and %q", balances["alex"], out.String())
+	}
+	want := "charge 30, charge 50, charge 10, refund 10"
+	if got := strings.Join(charges, ", "); got != want {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	// nothing that goes wrong in a script panics the host
+	var reversal *interpreter.UnhandledReversal
+	if _, err := e.Call("unpaid", "alex"); !errors.As(err, &reversal) || reversal.Value != "CANCELLED" {
+		t.Errorf("expected an unhandled reversal, got %v", err)
+	}
+	if balances["alex"] != 20 {
+		t.Errorf("expected the unhandled reversal to refund its charge, got a balance of %v", balances["alex"])
+	}
+	if _, err := interpreter.CallAs[string](e, "total", []int{1}); err == nil {
+		t.Error("expected an error converting an int result to a string")
+	}
+	if _, err := e.Call("byItems"); err == nil {
+		t.Error("expected an error returning a map with keys Go maps cannot have")
+	}
+	if _, err := interpreter.CallAs[map[interface{}]int](e, "byItems"); err == nil {
+		t.Error("expected an error converting a map with keys Go maps cannot have")
+	}
+	if _, err := e.Call("missing"); err == nil {
+		t.Error("expected an error calling a function that is not defined")
+	}
+	if _, err := e.Call("total", struct{}{}); err == nil {
+		t.Error("expected an error passing a value scripts cannot use")
+	}
+	if err := e.Load("func broken( {"); err == nil {
+		t.Error("expected an error loading a script that does not parse")
+	}
+	if err := e.Register("charge", charge, nil); err == nil {
+		t.Error("expected an error registering a native twice")
+	}
+	if err := e.Register("bad", func(ch chan int) {}, nil); err == nil {
+		t.Error("expected an error registering a native with a parameter scripts cannot pass")
+	}
+	if err := e.Register("badInverse", charge, func(account string) {}); err == nil {
+		t.Error("expected an error registering an inverse that takes other parameters")
+	}
+}
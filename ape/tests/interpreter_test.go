@@ -0,0 +1,1340 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pcen/ape/ape/interpreter"
+)
+
+const (
+	reverseList = `
+	xs := [1, 2, 3]
+	ys := [4, 5]
+	zs := [6]
+
+	func main() {
+		skip {
+			xs.push(4)
+			xs.push(5)
+			xs[0] = 100
+			xs[-1]++
+			ys.pop()
+			ys.pop()
+			zs.pop()
+			zs.push(7)
+			reverse "LIST"
+		} seize "LIST" {
+			xs.push(0)
+		}
+	}
+	`
+
+	reverseMap = `
+	m := {"alex": 100}
+	bank := {"a": {"x": 1}}
+
+	func main() {
+		skip {
+			m["reenus"] = 1
+			m["alex"] += 1
+			delete(m, "alex")
+			bank["a"]["y"] = 2
+			bank["a"]["x"] = 5
+			delete(bank["a"], "x")
+			reverse "MAP"
+		} seize "MAP" {
+		}
+	}
+	`
+
+	reverseNatives = `
+	func main() {
+		skip {
+			touch("DIR/emptied")
+			write("DIR/kept", "new")
+			write("DIR/written", "new")
+			touch("DIR/touched")
+			delete("DIR/deleted")
+			move("DIR/moved", "DIR/replaced")
+			move("DIR/replaced", "DIR/renamed")
+			reverse "IO"
+		} seize "IO" {
+		}
+		skip {
+			delete("DIR/gone")
+			touch("DIR/truncated")
+		}
+	}
+	`
+
+	kvStore = `
+	seen := ""
+	during := ""
+	nested := ""
+
+	func main() {
+		db := kv_open("DIR/store.kv")
+		kv_put(db, "seats", "2")
+		skip {
+			kv_put(db, "seats", "1")
+			kv_put(db, "alex", "booked")
+			reverse "FULL"
+		} seize "FULL" {
+		}
+		skip {
+			kv_put(db, "reenus", "booked")
+			kv_put(db, "seats", "1")
+			seen = kv_get(db, "seats")
+			skip {
+				kv_delete(db, "seats")
+				nested = kv_get(db, "seats")
+				reverse "NO"
+			} seize "NO" {
+			}
+			during = read("DIR/store.kv")
+		}
+	}
+	`
+
+	kvNestedReturn = `
+	func book(db string) string {
+		skip {
+			kv_put(db, "alex", "booked")
+			return kv_get(db, "alex")
+		}
+		return ""
+	}
+
+	func main() {
+		db := kv_open("DIR/store.kv")
+		skip {
+			book(db)
+			reverse "FULL"
+		} seize "FULL" {
+		}
+	}
+	`
+
+	kvTasks = `
+	seen := ""
+
+	func book(db string, name string, fail bool, turn chan int) int {
+		skip {
+			kv_put(db, "seat", name)
+			send(turn, 1)
+			recv(turn)
+			if fail {
+				reverse "FULL"
+			}
+		} seize "FULL" {
+		}
+		return 0
+	}
+
+	func main() {
+		db := kv_open("DIR/store.kv")
+		first := channel(0)
+		second := channel(0)
+		a := spawn book(db, "alex", false, first)
+		recv(first)
+		b := spawn book(db, "reenus", true, second)
+		recv(second)
+		seen = kv_get(db, "seat")
+		send(first, 1)
+		join(a)
+		send(second, 1)
+		join(b)
+	}
+	`
+
+	kvReopened = `
+	seats := ""
+	alex := true
+
+	func main() {
+		db := kv_open("DIR/store.kv")
+		seats = kv_get(db, "seats")
+		alex = kv_has(db, "alex")
+	}
+	`
+
+	saga = `
+	func book(fail string) {
+		skip {
+			order := http_post("URL/orders", "{}") @undo http_delete("URL/orders/" + it)
+			http_post("URL/payments", order) @undo http_delete("URL/payments/" + it)
+			http_post("URL/shipments" + fail, order) @undo http_delete("URL/shipments/" + it)
+			if fail == "" {
+				reverse "CANCELLED"
+			}
+		} seize {
+		}
+	}
+
+	func main() {
+		book("")
+		book("/unavailable")
+	}
+	`
+
+	savepoints = `
+	balance := 100
+	log := ["start"]
+	undone := ["start"]
+
+	func main() {
+		skip {
+			balance -= 10
+			log.push("reserved")
+			savepoint "charged"
+			balance -= 50
+			log.push("charged")
+			reverse to "charged"
+			log.push("retried")
+			balance -= 20
+			reverse to "charged"
+			reverse to "charged"
+			log.push("done")
+		}
+		skip {
+			undone.push("reserved")
+			savepoint "charged"
+			undone.push("charged")
+			reverse to "charged"
+			reverse "ALL"
+		} seize "ALL" {
+		}
+	}
+	`
+
+	variableTo = `
+	to := 3
+	seized := 0
+
+	func main() {
+		to = to + 1
+		skip {
+			reverse to
+		} seize n: int {
+			seized = n
+		}
+	}
+	`
+
+	retries = `
+	log := ["start"]
+	succeeded := 0
+	gaveUp := 0
+	outerSeizes := 0
+	nested := 0
+
+	func main() {
+		skip {
+			log.push("try")
+			succeeded = attempt
+			if attempt < 3 {
+				reverse "FLAKY"
+			}
+		} seize "FLAKY" {
+			retry attempt: 5
+		}
+		skip {
+			log.push("down")
+			reverse "DOWN"
+		} seize "DOWN" {
+			retry 2
+			gaveUp += 1
+		}
+		skip {
+			nested = outer
+			skip {
+				reverse "IN"
+			} seize "IN" {
+				retry 3
+				reverse "OUT"
+			}
+		} seize "OUT" {
+			outerSeizes += 1
+			retry outer: 2
+		}
+	}
+	`
+
+	always = `
+	order := ["start"]
+	tries := 0
+	replaced := ""
+
+	func main() {
+		skip {
+			order.push("body")
+			tries = attempt
+			reverse attempt
+		} seize n: int {
+			order.push("seize")
+			retry attempt: 3
+		} always {
+			order.push("always")
+			tries += 10
+		}
+		skip {
+			skip {
+				reverse "first"
+			} always {
+				reverse "second"
+			}
+		} seize s: string {
+			replaced = s
+		}
+	}
+	`
+
+	tasks = `
+	balance := 100
+	kept := 0
+	received := 0
+	joined := 0
+	failure := ""
+
+	func withdraw(amount int, fail bool, done chan int) int {
+		skip {
+			balance -= amount
+			if fail {
+				reverse amount
+			}
+		} seize n: int {
+			amount = 0
+		}
+		send(done, amount)
+		return amount
+	}
+
+	func divide(n int) int {
+		return n / 0
+	}
+
+	func main() {
+		done := channel(0)
+		a := spawn withdraw(10, false, done)
+		b := spawn withdraw(20, true, done)
+		skip {
+			kept = 1
+			spawn withdraw(30, false, done)
+			reverse "undone"
+		} seize s: string {
+		}
+		received = recv(done) + recv(done) + recv(done)
+		joined = join(a) + join(b)
+		skip {
+			join(spawn divide(1))
+		} seize err: RuntimeError {
+			failure = err.message
+		}
+	}
+	`
+
+	transactions = `
+	counter := 0
+	other := 0
+	tries := channel(10)
+	otherTries := channel(10)
+
+	func bump(ready chan int, gate chan int) {
+		atomic skip {
+			n := counter
+			send(tries, n)
+			if n == 0 {
+				send(ready, 1)
+				recv(gate)
+			}
+			counter = n + 1
+		}
+	}
+
+	func add(ready chan int, gate chan int) {
+		atomic skip {
+			n := other
+			send(otherTries, n)
+			send(ready, 1)
+			recv(gate)
+			other = n + 10
+		}
+	}
+
+	func main() {
+		ready := channel(0)
+		gate := channel(3)
+		a := spawn bump(ready, gate)
+		b := spawn bump(ready, gate)
+		c := spawn add(ready, gate)
+		recv(ready)
+		recv(ready)
+		recv(ready)
+		send(gate, 0)
+		send(gate, 0)
+		send(gate, 0)
+		join(a)
+		join(b)
+		join(c)
+	}
+	`
+
+	nestedTransaction = `
+	counter := 0
+
+	func bump(started chan int) {
+		send(started, 1)
+		atomic skip {
+			counter = counter + 1
+		}
+		send(started, 1)
+	}
+
+	func main() {
+		started := channel(0)
+		skip {
+			atomic skip {
+				counter = counter + 1
+			}
+			spawn bump(started)
+			recv(started)
+			reverse "UNDO"
+		} seize "UNDO" {
+		}
+		recv(started)
+	}
+	`
+
+	typedSeizes = `
+	class Failure {
+		code int
+		message string
+		data []int
+	}
+
+	seats := 2
+	code := 0
+	message := ""
+	reason := ""
+	caught := ""
+
+	func book(n int) {
+		skip {
+			seats -= n
+			if seats < 0 {
+				reverse Failure(409, "NO_SEATS", [seats])
+			}
+			reverse "SOLD"
+		} seize err: Failure {
+			code = err.code
+			message = err.message
+		} seize why: string {
+			reason = why
+		}
+	}
+
+	func main() {
+		book(1)
+		book(5)
+		skip {
+			skip {
+				reverse 3
+			} seize 4 {
+				caught = "four"
+			}
+		} seize n: int {
+			caught = "int"
+		} seize {
+			caught = "anything"
+		}
+	}
+	`
+
+	runtimeErrors = `
+	balance := 10
+	message := ""
+	line := 0
+	m := {"a": 1}
+	caught := 0
+
+	func main() {
+		skip {
+			balance -= 5
+			balance = balance / 0
+		} seize err: RuntimeError {
+			message = err.message
+			line = err.line
+		}
+		skip {
+			balance -= 5
+			x := m["missing"]
+		} seize err: RuntimeError {
+			caught += 1
+		}
+		skip {
+			balance -= 5
+			read("DIR/missing")
+		} seize err: RuntimeError {
+			caught += 1
+		}
+		skip {
+			balance -= 5
+			s := "a" - 1
+		} seize err: RuntimeError {
+			caught += 1
+		}
+	}
+	`
+
+	unseizedRuntimeError = `
+	balance := 10
+
+	func notify(amount int) {
+	}
+
+	func retract(amount int) {
+	}
+
+	func main() {
+		skip {
+			balance -= 5
+			notify(5) @undo retract(5)
+			balance = balance % 0
+		} seize "OTHER" {
+		}
+	}
+	`
+
+	committed = `
+	balance := 100
+	log := ["start"]
+
+	func main() {
+		skip {
+			balance -= 10
+			skip {
+				log.push("inner")
+				commit
+				log.push("uncommitted")
+				reverse
+			} seize {
+			}
+			savepoint "before"
+			log.push("reserved")
+			println("committed")
+			commit
+			balance -= 20
+			println("reverted")
+			reverse "DONE"
+		} seize "DONE" {
+		}
+	}
+	`
+
+	nestedCommit = `
+	func main() {
+		skip {
+			println("outer")
+			skip {
+				println("inner")
+				commit
+				println("after commit")
+			}
+			reverse "DONE"
+		} seize "DONE" {
+		}
+	}
+	`
+
+	revFuncs = `
+	n := 4
+	a := 0
+	b := 0
+	xs := [6, 7]
+	called := [0]
+
+	rev func fib(n int, x1 int, x2 int) {
+		if n == 0 {
+			x1 += 1
+			x2 += 1
+		} else {
+			n -= 1
+			call fib(n, x1, x2)
+			x1 += x2
+			x1 <=> x2
+		} fi x1 == x2
+	}
+
+	rev func mask(xs []int, key int) {
+		xs[0] ^= key
+		xs[1] ^= key
+		xs[0] <=> xs[1]
+	}
+
+	func main() {
+		call fib(n, a, b)
+		called.push(n, a, b)
+		uncall fib(n, a, b)
+		key := 5
+		call mask(xs, key)
+		called.push(xs[0], xs[1])
+		uncall mask(xs, key)
+		skip {
+			call fib(n, a, b)
+			reverse
+		} seize {
+		}
+	}
+	`
+
+	debugged = `
+	total := 0
+	xs := [1]
+	m := {"a": 1}
+
+	rev func double(n int) {
+		n += n
+	}
+
+	func add(n int) {
+		total += n
+		xs.push(n)
+		m["a"] = n
+	}
+
+	func main() {
+		add(2)
+		skip {
+			add(3)
+			xs[0] = 9
+			k := 1
+			call double(k)
+			total += k
+			reverse
+		} seize {
+		}
+		xs.pop()
+		add(4)
+	}
+	`
+
+	bufferedOutput = `
+	func main() {
+		println("before")
+		skip {
+			println("kept")
+			skip {
+				println("inner kept")
+			}
+			skip {
+				println("inner reverted")
+				reverse
+			} seize {
+				println("seized")
+			}
+			savepoint "s"
+			println("after savepoint")
+			reverse to "s"
+		}
+		skip {
+			println("outer reverted")
+			reverse
+		} seize {
+			println("outer seized")
+		}
+	}
+	`
+
+	compacted = `
+	x := 0
+	xs := [0, 0]
+	m := {"k": 0}
+	seen := [0]
+
+	func noop() {
+	}
+
+	func record(v int) {
+		seen.push(v)
+	}
+
+	func main() {
+		skip {
+			for i := 0; i < 100; i++ {
+				x += 1
+				xs[1] = i
+				m["k"] = i
+			}
+			xs.push(5)
+			xs[2] = 6
+			xs[1] = 7
+			noop() @undo record(x)
+			for i := 0; i < 100; i++ {
+				x += 1
+				m["k"] = i
+			}
+			reverse
+		} seize {
+		}
+	}
+	`
+
+	declarations = `
+	total := 0
+
+	func scratch() int {
+		t := 1
+		t := t + 1
+		return t
+	}
+
+	func main() {
+		a := 1
+		skip {
+			savepoint "s"
+			b := 2
+			a := 3
+			for i := 0; i < 2; i++ {
+				total += scratch()
+			}
+			reverse to "s"
+			total += a
+		}
+	}
+	`
+
+	boundCompensations = `
+	removed := ["-"]
+	destroyed := [0]
+	next := 0
+
+	func open(name string) {
+	}
+
+	func remove(name string) {
+		removed.push(name)
+	}
+
+	func create() int {
+		next += 1
+		return next
+	}
+
+	func destroy(id int) {
+		destroyed.push(id)
+	}
+
+	func main() {
+		skip {
+			file := "a.txt"
+			open(file) @undo remove(file)
+			file = "b.txt"
+			id := create() @undo destroy(it)
+			create() @undo destroy(it + id)
+			reverse
+		} seize {
+		}
+	}
+	`
+
+	unhandledReversal = `
+	seats := 1
+
+	func charge(name string) {
+	}
+
+	func refund(name string) {
+	}
+
+	func release(seat int) {
+	}
+
+	func book(name string) {
+		skip {
+			seats -= 1
+			charge(name) @undo refund(name)
+			reverse "NO_SEATS"
+		} seize "OTHER" {
+		}
+	}
+
+	func main() {
+		skip {
+			book("alex") @undo release(1)
+		} seize "NOT_THIS" {
+		}
+	}
+	`
+
+	longSkip = `
+	x := 0
+
+	func main() {
+		skip {
+			for i := 0; i < 100000; i++ {
+				x += 1
+			}
+			println("measure")
+			reverse
+		} seize {
+		}
+	}
+	`
+)
+
+func TestReverseList(t *testing.T) {
+	twi := Interpret(reverseList)
+	expect := map[string]string{
+		"xs": "[1, 2, 3, 0]",
+		"ys": "[4, 5]",
+		"zs": "[6]",
+	}
+	for name, want := range expect {
+		if got := twi.GlobalScope.Get(name).ToString(); got != want {
+			t.Errorf("%v after reversal: expected %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestReverseMap(t *testing.T) {
+	twi := Interpret(reverseMap)
+	expect := map[string]string{
+		"m":    "alex: 100",
+		"bank": "a: x: 1",
+	}
+	for name, want := range expect {
+		if got := twi.GlobalScope.Get(name).ToString(); got != want {
+			t.Errorf("%v after reversal: expected %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestNativeInverses(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"kept": "old", "deleted": "old", "moved": "old", "replaced": "other", "emptied": "old"}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"gone", "truncated"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("old"), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	Interpret(strings.ReplaceAll(reverseNatives, "DIR", dir))
+	if got, err := os.ReadFile(filepath.Join(dir, "truncated")); err != nil || len(got) != 0 {
+		t.Errorf("expected touch to empty the file, got %q (%v)", got, err)
+	}
+
+	for name, want := range files {
+		if got, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(got) != want {
+			t.Errorf("%v after reversal: expected %q, got %q (%v)", name, want, got, err)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != len(files)+1 {
+		for _, e := range entries {
+			t.Log(e.Name())
+		}
+		t.Errorf("expected only the restored files to remain, got %v files", len(entries))
+	}
+}
+
+func TestKVStore(t *testing.T) {
+	dir := t.TempDir()
+	twi := Interpret(strings.ReplaceAll(kvStore, "DIR", dir))
+	expect := map[string]string{
+		"seen":   "1",
+		"nested": "",
+		"during": `{"seats":"2"}`,
+	}
+	for name, want := range expect {
+		if got := twi.GlobalScope.Get(name).ToString(); got != want {
+			t.Errorf("%v: expected %v, got %v", name, want, got)
+		}
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "store.kv")); err != nil || string(got) != `{"reenus":"booked","seats":"1"}` {
+		t.Errorf("expected the finished skip block to be committed, got %q (%v)", got, err)
+	}
+
+	// another interpreter only sees what was committed
+	twi = Interpret(strings.ReplaceAll(kvReopened, "DIR", dir))
+	if seats := twi.GlobalScope.Get("seats").ToString(); seats != "1" {
+		t.Errorf("seats after reopening: expected 1, got %v", seats)
+	}
+	if alex := twi.GlobalScope.Get("alex").ToString(); alex != "False" {
+		t.Errorf("expected the reversed put to be discarded, got alex %v", alex)
+	}
+}
+
+func TestKVStoreNestedReturn(t *testing.T) {
+	// a return out of a nested skip leaves its changes to the enclosing skip, which reverses them
+	dir := t.TempDir()
+	Interpret(strings.ReplaceAll(kvNestedReturn, "DIR", dir))
+	twi := Interpret(strings.ReplaceAll(kvReopened, "DIR", dir))
+	if alex := twi.GlobalScope.Get("alex").ToString(); alex != "False" {
+		t.Errorf("expected the put of the nested skip to be reversed, got alex %v", alex)
+	}
+}
+
+func TestKVStoreTasks(t *testing.T) {
+	// both tasks have a put to the same key staged when main looks at it
+	dir := t.TempDir()
+	twi := Interpret(strings.ReplaceAll(kvTasks, "DIR", dir))
+	if seen := twi.GlobalScope.Get("seen").ToString(); seen != "" {
+		t.Errorf("expected main not to see the puts the tasks staged, got %v", seen)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "store.kv")); err != nil || string(got) != `{"seat":"alex"}` {
+		t.Errorf("expected only the task that finished to commit its put, got %q (%v)", got, err)
+	}
+}
+
+func TestHTTPSaga(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	ids := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, strings.TrimSpace(r.Method+" "+r.URL.Path+" "+string(body)))
+		if r.URL.Path == "/shipments/unavailable" {
+			http.Error(w, "no couriers", http.StatusServiceUnavailable)
+			return
+		}
+		// each resource gets its own ids
+		ids[r.URL.Path]++
+		fmt.Fprint(w, ids[r.URL.Path])
+	}))
+	defer server.Close()
+
+	Interpret(strings.ReplaceAll(saga, "URL", server.URL))
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		// the reverse compensates every step, most recent first, with the id each step got
+		"POST /orders {}",
+		"POST /payments 1",
+		"POST /shipments 1",
+		"DELETE /shipments/1",
+		"DELETE /payments/1",
+		"DELETE /orders/1",
+		// a failed request reverses the skip like a runtime error
+		"POST /orders {}",
+		"POST /payments 2",
+		"POST /shipments/unavailable 2",
+		"DELETE /payments/2",
+		"DELETE /orders/2",
+	}
+	if strings.Join(requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected requests:\n%v\ngot:\n%v", strings.Join(want, "\n"), strings.Join(requests, "\n"))
+	}
+}
+
+func TestSavepoints(t *testing.T) {
+	twi := Interpret(savepoints)
+	expect := map[string]string{
+		"balance": "90",
+		"log":     "[start, reserved, done]",
+		"undone":  "[start]",
+	}
+	for name, want := range expect {
+		if got := twi.GlobalScope.Get(name).ToString(); got != want {
+			t.Errorf("%v after reversal: expected %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestVariableNamedTo(t *testing.T) {
+	// to is only a keyword when a savepoint name follows it
+	twi := Interpret(variableTo)
+	if got := twi.GlobalScope.Get("seized").ToString(); got != "4" {
+		t.Errorf("expected the value of to to be reversed with, got %v", got)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	twi := Interpret(retries)
+	expect := map[string]string{
+		"log":         "[start, try]",
+		"succeeded":   "3",
+		"gaveUp":      "1",
+		"outerSeizes": "2",
+		"nested":      "0",
+	}
+	for name, want := range expect {
+		if got := twi.GlobalScope.Get(name).ToString(); got != want {
+			t.Errorf("%v after retries: expected %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestAlways(t *testing.T) {
+	twi := Interpret(always)
+	expect := map[string]string{
+		"order":    "[start, seize, always, seize, always, seize, always]",
+		"tries":    "30",
+		"replaced": "second",
+	}
+	for name, want := range expect {
+		if got := twi.GlobalScope.Get(name).ToString(); got != want {
+			t.Errorf("%v after always clauses: expected %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestTasks(t *testing.T) {
+	twi := Interpret(tasks)
+	expect := map[string]string{
+		// the reverse in one task leaves the others alone, and the reverse in main
+		// does not reach the task spawned inside its skip block
+		"balance":  "60",
+		"kept":     "0",
+		"received": "40",
+		"joined":   "10",
+		"failure":  "task divide failed: 22:12: runtime error: integer divide by zero",
+	}
+	for name, want := range expect {
+		if got := twi.GlobalScope.Get(name).ToString(); got != want {
+			t.Errorf("%v after tasks: expected %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestAtomicSkip(t *testing.T) {
+	twi := Interpret(transactions)
+	expect := map[string]string{
+		// every transaction reads what it changes before any of them commits, so the bump
+		// that commits second is reversed and runs again, the add touches nothing else
+		"counter":    "2",
+		"other":      "10",
+		"tries":      "CHAN 3/10",
+		"otherTries": "CHAN 1/10",
+	}
+	for name, want := range expect {
+		if got := twi.GlobalScope.Get(name).ToString(); got != want {
+			t.Errorf("%v after transactions: expected %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestNestedAtomicSkip(t *testing.T) {
+	// the transaction inside the skip block owns counter until the skip block is reversed,
+	// so the one in the task cannot build on the increment that is reversed
+	for i := 0; i < 20; i++ {
+		twi := Interpret(nestedTransaction)
+		if got := twi.GlobalScope.Get("counter").ToString(); got != "1" {
+			t.Fatalf("counter after nested transaction: expected 1, got %v", got)
+		}
+	}
+}
+
+func TestTypedSeizes(t *testing.T) {
+	twi := Interpret(typedSeizes)
+	expect := map[string]string{
+		"seats":   "2",
+		"code":    "409",
+		"message": "NO_SEATS",
+		"reason":  "SOLD",
+		"caught":  "int",
+	}
+	for name, want := range expect {
+		if got := twi.GlobalScope.Get(name).ToString(); got != want {
+			t.Errorf("%v after reversal: expected %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestRuntimeErrorsReverse(t *testing.T) {
+	twi := Interpret(strings.ReplaceAll(runtimeErrors, "DIR", t.TempDir()))
+	expect := map[string]string{
+		"balance": "10",
+		"message": "integer divide by zero",
+		"line":    "11",
+		"caught":  "3",
+	}
+	for name, want := range expect {
+		if got := twi.GlobalScope.Get(name).ToString(); got != want {
+			t.Errorf("%v after runtime errors: expected %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestRuntimeErrorOutsideSkip(t *testing.T) {
+	twi := Load(unseizedRuntimeError)
+	err := twi.RunMain()
+	rerr, ok := err.(interpreter.RuntimeError)
+	if !ok {
+		t.Fatalf("expected a runtime error, got %v", err)
+	}
+	want := "14:22: runtime error: integer divide by zero\n" +
+		"  reversed skip at 11:6 in main\n" +
+		"  compensations run:\n" +
+		"    retract(5)"
+	if rerr.Error() != want {
+		t.Errorf("expected the runtime error to be traced as\n%v\ngot\n%v", want, rerr.Error())
+	}
+	if got := twi.GlobalScope.Get("balance").ToString(); got != "10" {
+		t.Errorf("balance after runtime error: expected 10, got %v", got)
+	}
+}
+
+func TestUnhandledReversal(t *testing.T) {
+	twi := Load(unhandledReversal)
+	err := twi.RunMain()
+	unhandled, ok := err.(*interpreter.UnhandledReversal)
+	if !ok {
+		t.Fatalf("expected an unhandled reversal, got %v", err)
+	}
+	want := "17:10: unhandled reversal of NO_SEATS\n" +
+		"  reversed skip at 14:6 in book\n" +
+		"  reversed skip at 23:6 in main\n" +
+		"  compensations run:\n" +
+		"    refund(alex)\n" +
+		"    release(1)"
+	if unhandled.Error() != want {
+		t.Errorf("expected the reversal to be traced as\n%v\ngot\n%v", want, unhandled.Error())
+	}
+	if got := twi.GlobalScope.Get("seats").ToString(); got != "1" {
+		t.Errorf("seats after unhandled reversal: expected 1, got %v", got)
+	}
+}
+
+func TestBufferedOutput(t *testing.T) {
+	var stdout, reverted bytes.Buffer
+	twi := Load(bufferedOutput)
+	twi.Stdout, twi.Reverted, twi.BufferOutput = &stdout, &reverted, true
+	if err := twi.RunMain(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "before\nkept\ninner kept\nseized\nouter seized\n"; stdout.String() != want {
+		t.Errorf("expected output %q, got %q", want, stdout.String())
+	}
+	if want := "inner reverted\nafter savepoint\nouter reverted\n"; reverted.String() != want {
+		t.Errorf("expected reverted output %q, got %q", want, reverted.String())
+	}
+}
+
+func TestCommit(t *testing.T) {
+	var stdout, reverted bytes.Buffer
+	twi := Load(committed)
+	twi.Stdout, twi.Reverted, twi.BufferOutput = &stdout, &reverted, true
+	if err := twi.RunMain(); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{
+		"balance": "90",
+		"log":     "[start, inner, reserved]",
+	}
+	for name, want := range expect {
+		if got := twi.GlobalScope.Get(name).ToString(); got != want {
+			t.Errorf("%v after commit: expected %v, got %v", name, want, got)
+		}
+	}
+	if stdout.String() != "committed\n" || reverted.String() != "reverted\n" {
+		t.Errorf("expected only the output after the commit to be reverted, got %q and %q", stdout.String(), reverted.String())
+	}
+}
+
+func TestNestedCommitOutput(t *testing.T) {
+	// the inner commit only keeps the output of the inner skip, the outer skip still reverts its own
+	var stdout, reverted bytes.Buffer
+	twi := Load(nestedCommit)
+	twi.Stdout, twi.Reverted, twi.BufferOutput = &stdout, &reverted, true
+	if err := twi.RunMain(); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "inner\n" || reverted.String() != "outer\nafter commit\n" {
+		t.Errorf("expected only the committed output of the inner skip to be kept, got %q and %q", stdout.String(), reverted.String())
+	}
+}
+
+func TestRevFuncs(t *testing.T) {
+	twi := Interpret(revFuncs)
+	expect := map[string]string{
+		"n":      "4",
+		"a":      "0",
+		"b":      "0",
+		"xs":     "[6, 7]",
+		"called": "[0, 0, 5, 8, 2, 3]",
+	}
+	for name, want := range expect {
+		if got := twi.GlobalScope.Get(name).ToString(); got != want {
+			t.Errorf("%v after uncall: expected %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestDebugger(t *testing.T) {
+	twi := Load(debugged)
+	debugger := interpreter.NewDebugger(twi)
+	if !debugger.Start() {
+		t.Fatal("main has no statements")
+	}
+	// the globals before each statement, and after main returns
+	states := []string{twi.GlobalScope.String()}
+	for debugger.Forward() {
+		states = append(states, twi.GlobalScope.String())
+	}
+	if want := "m = a: 4, total = 6, xs = [1, 4]"; states[len(states)-1] != want {
+		t.Fatalf("expected %v after main, got %v", want, states[len(states)-1])
+	}
+
+	for i := len(states) - 2; i >= 0; i-- {
+		if !debugger.Back() {
+			t.Fatalf("could not step back to statement %v", i)
+		}
+		if got := twi.GlobalScope.String(); got != states[i] {
+			t.Errorf("stepping back to statement %v at %v: expected %v, got %v", i, debugger.Current().Pos, states[i], got)
+		}
+	}
+	if debugger.Back() {
+		t.Error("stepped back from the first statement")
+	}
+	for i := 1; i < len(states); i++ {
+		debugger.Forward()
+		if got := twi.GlobalScope.String(); got != states[i] {
+			t.Errorf("stepping forward again to statement %v: expected %v, got %v", i, states[i], got)
+		}
+	}
+	if !debugger.Finished() || debugger.Err != nil {
+		t.Errorf("expected main to have returned, got %v", debugger.Err)
+	}
+}
+
+func TestUndoLogCompaction(t *testing.T) {
+	for _, full := range []bool{false, true} {
+		twi := Load(compacted)
+		twi.FullUndoLog = full
+		if err := twi.RunMain(); err != nil {
+			t.Fatal(err)
+		}
+		// the compensation sees x as it was when it was registered
+		expect := map[string]string{
+			"x":    "0",
+			"xs":   "[0, 0]",
+			"m":    "k: 0",
+			"seen": "[0, 100]",
+		}
+		for name, want := range expect {
+			if got := twi.GlobalScope.Get(name).ToString(); got != want {
+				t.Errorf("%v after reversal with full undo log %v: expected %v, got %v", name, full, want, got)
+			}
+		}
+	}
+}
+
+// heapWriter measures the live heap whenever the program prints, keeping the largest
+type heapWriter struct {
+	live uint64
+}
+
+func liveHeap() uint64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+func (w *heapWriter) Write(p []byte) (int, error) {
+	if live := liveHeap(); live > w.live {
+		w.live = live
+	}
+	return len(p), nil
+}
+
+// go test -run XXX -bench UndoLog ./ape/tests
+func BenchmarkUndoLog(b *testing.B) {
+	for _, full := range []bool{false, true} {
+		name := "compacted"
+		if full {
+			name = "full"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			var retained uint64
+			for i := 0; i < b.N; i++ {
+				twi := Load(longSkip)
+				twi.FullUndoLog = full
+				heap := &heapWriter{}
+				twi.Stdout = heap
+				before := liveHeap()
+				if err := twi.RunMain(); err != nil {
+					b.Fatal(err)
+				}
+				// the heap grown by the end of the skip body is mostly the undo log
+				if heap.live > before {
+					retained += heap.live - before
+				}
+			}
+			b.ReportMetric(float64(retained)/float64(b.N), "undo-B/op")
+		})
+	}
+}
+
+func TestDeclarationsReverse(t *testing.T) {
+	twi := Load(declarations)
+	debugger := interpreter.NewDebugger(twi)
+	debugger.Start()
+
+	// the scope chain of each statement when main first reaches it
+	var states [][]map[string]string
+	lines := make(map[uint]int)
+	for !debugger.Finished() {
+		step := debugger.Current()
+		if _, ok := lines[step.Pos.Line]; !ok {
+			lines[step.Pos.Line] = len(states)
+		}
+		states = append(states, State(step.Scope))
+		debugger.Forward()
+	}
+	if debugger.Err != nil {
+		t.Fatal(debugger.Err)
+	}
+
+	// reversing to the savepoint removes b and i and restores a, in the scope of the skip body
+	line := func(stmt string) int {
+		return lines[uint(strings.Count(declarations[:strings.Index(declarations, stmt)], "\n")+1)]
+	}
+	if diff := Diff(states[line("b := 2")], states[line("total += a")]); len(diff) > 0 {
+		t.Errorf("expected the skip body to be as it was at the savepoint, got %v", diff)
+	}
+	if got := twi.GlobalScope.Get("total").ToString(); got != "1" {
+		t.Errorf("expected total 1, got %v", got)
+	}
+
+	// stepping back undoes declarations in every scope, including the functions called
+	for i := len(states) - 1; i >= 0; i-- {
+		debugger.Back()
+		if diff := Diff(states[i], State(debugger.Current().Scope)); len(diff) > 0 {
+			t.Errorf("stepping back to statement %v at %v: %v", i, debugger.Current().Pos, diff)
+		}
+	}
+}
+
+func TestBoundCompensations(t *testing.T) {
+	twi := Interpret(boundCompensations)
+	// compensations get the arguments they had when the statement they annotate ran
+	expect := map[string]string{
+		"removed":   "[-, a.txt]",
+		"destroyed": "[0, 3, 1]",
+		"next":      "0",
+	}
+	for name, want := range expect {
+		if got := twi.GlobalScope.Get(name).ToString(); got != want {
+			t.Errorf("%v after reversal: expected %v, got %v", name, want, got)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package tests
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pcen/ape/ape/interpreter"
+)
+
+const (
+	// the interpreter kills itself inside the outer skip block
+	crashingSkip = `
+	func main() {
+		skip {
+			touch("DIR/committed") @undo delete("DIR/committed")
+		}
+		skip {
+			touch("DIR/outer") @undo delete("DIR/outer")
+			skip {
+				touch("DIR/inner") @undo delete("DIR/inner")
+			}
+			shell("kill -9 $PPID")
+		}
+	}
+	`
+
+	// the interpreter kills itself with key-value store changes staged
+	crashingStore = `
+	func main() {
+		db := kv_open("DIR/store.kv")
+		skip {
+			kv_put(db, "committed", "yes")
+		}
+		skip {
+			kv_put(db, "staged", "yes")
+			kv_delete(db, "committed")
+			shell("kill -9 $PPID")
+		}
+	}
+	`
+
+	// the interpreter kills itself while a task it spawned is inside a skip block
+	crashingTasks = `
+	func worker(started chan int) int {
+		skip {
+			touch("DIR/task") @undo delete("DIR/task")
+			send(started, 1)
+			recv(started)
+		}
+		return 0
+	}
+
+	func main() {
+		started := channel(0)
+		skip {
+			touch("DIR/main") @undo delete("DIR/main")
+			spawn worker(started)
+			recv(started)
+			shell("kill -9 $PPID")
+		}
+	}
+	`
+)
+
+func TestJournalRecovery(t *testing.T) {
+	// the test binary runs itself again to have a process that can die
+	if dir := os.Getenv("APE_CRASH_DIR"); dir != "" {
+		twi := Load(strings.ReplaceAll(crashingSkip, "DIR", dir))
+		j, err := interpreter.OpenJournal(filepath.Join(dir, "crash.ape.journal"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		twi.Journal = j
+		twi.RunMain()
+		return
+	}
+
+	dir := t.TempDir()
+	source := strings.ReplaceAll(crashingSkip, "DIR", dir)
+	path := filepath.Join(dir, "crash.ape.journal")
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestJournalRecovery$")
+	cmd.Env = append(os.Environ(), "APE_CRASH_DIR="+dir)
+	if err := cmd.Run(); err == nil {
+		t.Fatal("expected the interpreter to crash")
+	}
+
+	if pending, _ := interpreter.JournalPending(path); !pending {
+		t.Fatal("expected pending compensations in the journal")
+	}
+
+	if err := Load(source).Recover(path); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, exists := range map[string]bool{"committed": true, "outer": false, "inner": false} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists != (err == nil) {
+			t.Errorf("%v: expected exists=%v after recovery", name, exists)
+		}
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Error("expected journal to be removed after recovery")
+	}
+}
+
+func TestJournalOutsideSkip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plain.ape.journal")
+	j, err := interpreter.OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	twi := Load(`
+	func main() {
+		total := 0
+		for i := 0; i < 10; i++ {
+			total = total + i
+		}
+	}
+	`)
+	twi.Journal = j
+	twi.RunMain()
+
+	// nothing outside of a skip block is ever recovered, so nothing is written for it
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("expected an empty journal, got %v bytes", info.Size())
+	}
+}
+
+func TestKVStoreCrash(t *testing.T) {
+	if dir := os.Getenv("APE_CRASH_DIR"); dir != "" {
+		twi := Load(strings.ReplaceAll(crashingStore, "DIR", dir))
+		j, err := interpreter.OpenJournal(filepath.Join(dir, "crash.ape.journal"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		twi.Journal = j
+		twi.RunMain()
+		return
+	}
+
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestKVStoreCrash$")
+	cmd.Env = append(os.Environ(), "APE_CRASH_DIR="+dir)
+	if err := cmd.Run(); err == nil {
+		t.Fatal("expected the interpreter to crash")
+	}
+
+	// the staged changes have nothing to recover, the store is already as it was
+	if err := Load(strings.ReplaceAll(crashingStore, "DIR", dir)).Recover(filepath.Join(dir, "crash.ape.journal")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "store.kv")); err != nil || string(got) != `{"committed":"yes"}` {
+		t.Errorf("expected only the finished skip block in the store, got %q (%v)", got, err)
+	}
+}
+
+func TestTaskJournalRecovery(t *testing.T) {
+	if dir := os.Getenv("APE_CRASH_DIR"); dir != "" {
+		twi := Load(strings.ReplaceAll(crashingTasks, "DIR", dir))
+		j, err := interpreter.OpenJournal(filepath.Join(dir, "crash.ape.journal"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		twi.Journal = j
+		twi.RunMain()
+		return
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "crash.ape.journal")
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestTaskJournalRecovery$")
+	cmd.Env = append(os.Environ(), "APE_CRASH_DIR="+dir)
+	if err := cmd.Run(); err == nil {
+		t.Fatal("expected the interpreter to crash")
+	}
+
+	// the task has a journal of its own next to the program's
+	if pending, _ := interpreter.JournalPending(path); !pending {
+		t.Fatal("expected pending compensations in the journals")
+	}
+	if err := Load(strings.ReplaceAll(crashingTasks, "DIR", dir)).Recover(path); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"main", "task", "crash.ape.journal", "crash.ape.journal.task1"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("expected %v to be removed by recovery", name)
+		}
+	}
+}
@@ -95,10 +95,10 @@ func TestInsertAfterBrace(t *testing.T) {
 func TestSkipSeizeKeywords(t *testing.T) {
 	source := `
 		func bar() {
-			SKIP {
+			skip {
 				x := 1 * 2
-				REVERSE x
-			} SEIZE (2) {
+				reverse x
+			} seize (2) {
 				println("this is two")
 			}
 		}
@@ -53,14 +53,31 @@ var (
 	module test
 	func main(a int) {
 		b: int = 10
-		SKIP {
+		skip {
 			1 + 2
-			REVERSE x
-		} SEIZE(3) {
+			reverse x
+		} seize(3) {
 			b = 3
 		}
 		return b
 	}`
+
+	misplacedRetry = `
+	module test
+	func main() {
+		skip {
+			retry
+		} seize {
+			skip {
+			} seize {
+				retry
+			}
+			retry attempt: 3
+		} always {
+			retry
+		}
+		retry
+	}`
 )
 
 func TestParsing(t *testing.T) {
@@ -76,6 +93,13 @@ func TestParsing(t *testing.T) {
 	}
 }
 
+func TestMisplacedRetry(t *testing.T) {
+	_, errs := Parse(misplacedRetry)
+	if len(errs) != 3 {
+		t.Errorf("expected a parse error for each retry outside of a seize, got %v", errs)
+	}
+}
+
 // go test -run TestRandomParse ./ape/tests/*** -v
 func TestRandomParse(t *testing.T) {
 	fuzzDir := "../../tests/fuzz/"
@@ -2,9 +2,11 @@ package tests
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/pcen/ape/ape"
 	"github.com/pcen/ape/ape/ast"
+	"github.com/pcen/ape/ape/interpreter"
 )
 
 func Parse(source string) (*ast.File, []ape.ParseError) {
@@ -15,3 +17,62 @@ func Parse(source string) (*ast.File, []ape.ParseError) {
 	errors, _ := parser.Errors()
 	return node, errors
 }
+
+// Load interprets the declarations of a module-less program without running main
+func Load(source string) *interpreter.TWI {
+	tokens := ape.NewLexer().LexString(source)
+	prog := ape.NewParser(tokens).Program()
+	twi := interpreter.NewTWI()
+	for _, decl := range prog {
+		twi.Interpret(decl)
+	}
+	return twi
+}
+
+// Interpret runs the main function of a module-less program with the tree walking
+// interpreter, and returns the interpreter so its scopes can be inspected
+func Interpret(source string) *interpreter.TWI {
+	twi := Load(source)
+	if err := twi.RunMain(); err != nil {
+		panic(err)
+	}
+	return twi
+}
+
+// State records the bindings of a scope and of every scope enclosing it, innermost first
+func State(scope *interpreter.Scope) []map[string]string {
+	var state []map[string]string
+	for ; scope != nil; scope = scope.Enclosing {
+		bindings := make(map[string]string, len(scope.Values))
+		for name, val := range scope.Values {
+			bindings[name] = val.ToString()
+		}
+		state = append(state, bindings)
+	}
+	return state
+}
+
+// Diff describes every binding that differs between two states of the same scope chain,
+// it is empty when they are exactly the same
+func Diff(before, after []map[string]string) []string {
+	if len(before) != len(after) {
+		return []string{fmt.Sprintf("scope chain of %v scopes became %v", len(before), len(after))}
+	}
+	var diffs []string
+	for depth := range before {
+		for name, was := range before[depth] {
+			if now, ok := after[depth][name]; !ok {
+				diffs = append(diffs, fmt.Sprintf("scope %v: %v = %v was removed", depth, name, was))
+			} else if now != was {
+				diffs = append(diffs, fmt.Sprintf("scope %v: %v changed from %v to %v", depth, name, was, now))
+			}
+		}
+		for name, now := range after[depth] {
+			if _, ok := before[depth][name]; !ok {
+				diffs = append(diffs, fmt.Sprintf("scope %v: %v = %v was added", depth, name, now))
+			}
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
@@ -75,9 +75,11 @@ const (
 	Pipe       // |
 	Tilde      // ~
 	Caret      // ^
+	CaretEq    // ^=
 	ShiftRight // >>
 	ShiftLeft  // <<
 
+	Swap       // <=>
 	Dot        // .
 	Comma      // ,
 	Colon      // :
@@ -102,6 +104,21 @@ const (
 	Skip
 	Seize
 	Reverse
+	Savepoint
+	Retry
+	Commit
+	Always
+
+	// reversible procedure keywords
+	Rev
+	Call
+	Uncall
+	Fi
+
+	// concurrency keywords
+	Spawn
+	Chan
+	Atomic
 )
 
 var (
@@ -169,9 +186,11 @@ var (
 		Pipe:       "|",
 		Tilde:      "~",
 		Caret:      "^",
+		CaretEq:    "^=",
 		ShiftRight: ">>",
 		ShiftLeft:  "<<",
 
+		Swap:       "<=>",
 		Dot:        ".",
 		Comma:      ",",
 		Colon:      ":",
@@ -193,9 +212,22 @@ var (
 
 		At: "@",
 
-		Skip:    "skip",
-		Seize:   "seize",
-		Reverse: "reverse",
+		Skip:      "skip",
+		Seize:     "seize",
+		Reverse:   "reverse",
+		Savepoint: "savepoint",
+		Retry:     "retry",
+		Commit:    "commit",
+		Always:    "always",
+
+		Rev:    "rev",
+		Call:   "call",
+		Uncall: "uncall",
+		Fi:     "fi",
+
+		Spawn:  "spawn",
+		Chan:   "chan",
+		Atomic: "atomic",
 	}
 
 	keywords = map[string]Kind{
@@ -222,9 +254,22 @@ var (
 		"module":      Module,
 		"import":      Import,
 
-		"skip":    Skip,
-		"seize":   Seize,
-		"reverse": Reverse,
+		"skip":      Skip,
+		"seize":     Seize,
+		"reverse":   Reverse,
+		"savepoint": Savepoint,
+		"retry":     Retry,
+		"commit":    Commit,
+		"always":    Always,
+
+		"rev":    Rev,
+		"call":   Call,
+		"uncall": Uncall,
+		"fi":     Fi,
+
+		"spawn":  Spawn,
+		"chan":   Chan,
+		"atomic": Atomic,
 	}
 )
 
@@ -29,6 +29,18 @@ func (c *Checker) errUndefinedIdent(expr *ast.IdentExpr) {
 	c.err(expr.Ident.Position, "undefined identifier %v", expr.Ident.Lexeme)
 }
 
+// warn reports a warning, which is an error in strict mode
+func (c *Checker) warn(pos token.Position, format string, a ...interface{}) {
+	if c.Strict {
+		c.err(pos, format, a...)
+		return
+	}
+	c.Warnings = append(c.Warnings, checkerError{
+		pos: pos,
+		msg: fmt.Sprintf(format, a...),
+	})
+}
+
 func (c *Checker) err(pos token.Position, format string, a ...interface{}) {
 	c.Errors = append(c.Errors, checkerError{
 		pos: pos,
@@ -36,12 +48,45 @@ func (c *Checker) err(pos token.Position, format string, a ...interface{}) {
 	})
 }
 
+/** A reverse statement and the type of the value it reverses with */
+type reverseSite struct {
+	pos   token.Position
+	typ   Type
+	value *token.Token // the constant reversed, nil unless the reverse is of a literal
+}
+
+/** Keeps the first reverse of each type */
+func distinctReverses(reverses []reverseSite) (distinct []reverseSite) {
+	for _, rev := range reverses {
+		seen := false
+		for _, d := range distinct {
+			seen = seen || d.typ.Is(rev.typ)
+		}
+		if !seen {
+			distinct = append(distinct, rev)
+		}
+	}
+	return distinct
+}
+
 type Checker struct {
 	Scope      *Scope
 	scopeStack []*Scope
 	Types      map[ast.Expression]Type
 	File       *ast.File
 	Errors     []checkerError
+	Warnings   []checkerError
+	Strict     bool                       // report warnings as errors
+	skips      []*ast.SkipStmt            // skip blocks enclosing the statement being checked, innermost last
+	reverses   [][]reverseSite            // reverses that reach each enclosing skip body or function, innermost last
+	escapes    map[string][]reverseSite   // reverses that can escape each function, one for each type
+	effect     functionEffect             // effect of the function being checked
+	returns    Type                       // return type of the function being checked
+	effects    map[string]functionEffect  // effect of each function
+	undone     map[*ast.CallExpr]bool     // calls with an @undo annotation
+	undos      [][]*ast.CallExpr          // @undo calls not yet committed in each enclosing skip, innermost last
+	revs       map[string]bool            // functions declared with rev func
+	classes    map[string]map[string]Type // member types of each class
 }
 
 func NewChecker(File *ast.File) *Checker {
@@ -51,6 +96,11 @@ func NewChecker(File *ast.File) *Checker {
 		scopeStack: []*Scope{moduleScope},
 		Types:      make(map[ast.Expression]Type),
 		File:       File,
+		classes:    map[string]map[string]Type{RuntimeError.String(): runtimeErrorMembers},
+		escapes:    make(map[string][]reverseSite),
+		effects:    make(map[string]functionEffect),
+		undone:     make(map[*ast.CallExpr]bool),
+		revs:       make(map[string]bool),
 	}
 }
 
@@ -86,6 +136,25 @@ func (c *Checker) GatherModuleScope() {
 		}
 	}
 
+	// calling a class constructs an object from a value for each member
+	for _, d := range filter[*ast.ClassDecl](c.File.Ast) {
+		members := make(map[string]Type)
+		var params []Type
+		for _, m := range filter[*ast.MemberDecl](d.Body) {
+			typ, err := c.ResolveTypeNode(m.Type)
+			if err != nil {
+				c.err(m.Name.Position, "invalid type %v for member %v", m.Type, m.Name.Lexeme)
+			}
+			members[m.Name.Lexeme] = typ
+			params = append(params, typ)
+		}
+		c.classes[d.Name.Lexeme] = members
+		class, _ := c.Scope.LookupType(d.Name.Lexeme)
+		if err := c.Scope.DeclareSymbol(d.Name.Lexeme, NewFunction(params, []Type{class})); err != nil {
+			c.err(d.Name.Position, err.Error())
+		}
+	}
+
 	for _, d := range filter[*ast.FuncDecl](c.File.Ast) {
 		var returns Type
 		var ok bool
@@ -99,6 +168,7 @@ func (c *Checker) GatherModuleScope() {
 		if err := c.Scope.DeclareSymbol(d.Name.Lexeme, NewFunction(nil, []Type{returns})); err != nil {
 			c.err(d.Name.Position, err.Error())
 		}
+		c.revs[d.Name.Lexeme] = d.Rev
 	}
 
 	for _, d := range filter[*ast.VarDecl](c.File.Ast) {
@@ -115,13 +185,38 @@ func (c *Checker) GatherModuleScope() {
 
 func (c *Checker) Check() Environment {
 	c.GatherModuleScope()
-	for _, decl := range c.File.Ast {
-		switch d := decl.(type) {
-		case *ast.FuncDecl:
-			fmt.Println("type checking func", d.Name.Lexeme)
+	gathered := len(c.Errors)
+
+	// the reverses escaping a function and its effect reach every skip it is called from,
+	// so functions are checked again until neither changes for any of them
+	for changed := true; changed; {
+		c.Errors = c.Errors[:gathered]
+		c.Warnings = nil
+		changed = false
+		for _, d := range filter[*ast.FuncDecl](c.File.Ast) {
+			escaped, effect := len(c.escapes[d.Name.Lexeme]), c.effects[d.Name.Lexeme].effect
 			c.CheckDeclaration(d)
+			changed = changed || len(c.escapes[d.Name.Lexeme]) != escaped || c.effects[d.Name.Lexeme].effect != effect
 		}
 	}
+
+	// printed once the fixpoint is reached rather than on every pass
+	for _, d := range filter[*ast.FuncDecl](c.File.Ast) {
+		fmt.Println("type checking func", d.Name.Lexeme)
+		params := make([]Type, 0, len(d.Params))
+		for _, p := range d.Params {
+			params = append(params, c.Types[p.Type])
+		}
+		if fn, ok := c.Scope.Symbols[d.Name.Lexeme].(Function); ok {
+			fmt.Printf("%v signature: %v -> %v\n", d.Name, params, fn.Returns[0])
+		}
+	}
+	for _, rev := range c.escapes["main"] {
+		c.err(rev.pos, "reverse of type %v can execute outside any skip", rev.typ)
+	}
+	for _, w := range c.Warnings {
+		fmt.Println("warning:", w)
+	}
 	for _, e := range c.Errors {
 		fmt.Println(e)
 	}
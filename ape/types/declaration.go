@@ -2,7 +2,6 @@ package types
 
 import (
 	"errors"
-	"fmt"
 
 	"github.com/pcen/ape/ape/ast"
 )
@@ -18,6 +17,9 @@ func (c *Checker) ResolveTypeNode(n *ast.TypeExpr) (Type, error) {
 	if n.List {
 		typ = NewList(typ)
 	}
+	if n.Chan {
+		typ = NewChan(typ)
+	}
 	return typ, nil
 }
 
@@ -75,14 +77,31 @@ func (c *Checker) CheckDeclaration(decl ast.Declaration) {
 		if err != nil {
 			c.err(d.Name.Position, "undefined return type for %v: %v", d.Name.Lexeme, d.ReturnType.Name)
 		}
+		c.pushScope()
+		c.reverses = append(c.reverses, nil) // reverses that escape the function
+		c.effect = functionEffect{}
+		c.returns = retType
 		paramSignature := make([]Type, 0, len(d.Params))
 		for _, p := range d.Params {
 			c.CheckDeclaration(p)
 			paramSignature = append(paramSignature, c.Types[p.Type])
 		}
 		c.CheckStatement(d.Body)
+		if d.Rev {
+			if !retType.Is(Void) {
+				c.err(d.Name.Position, "rev func %v cannot return a value", d.Name.Lexeme)
+			}
+			c.checkReversible(d, d.Body)
+			// the uncall could not undo what its calls do outside of the interpreter
+			if c.effect.effect != Pure {
+				c.err(c.effect.path[0].pos, "rev func %v must be pure, but it calls %v", d.Name.Lexeme, c.effect.pathStr())
+			}
+		}
+		c.escapes[d.Name.Lexeme] = distinctReverses(c.reverses[len(c.reverses)-1])
+		c.reverses = c.reverses[:len(c.reverses)-1]
+		c.effects[d.Name.Lexeme] = c.effect
+		c.popScope()
 		c.Scope.DeclareSymbol(d.Name.Lexeme, NewFunction(paramSignature, []Type{retType}))
-		fmt.Printf("%v signature: %v -> %v\n", d.Name, paramSignature, retType)
 
 	case *ast.ParamDecl:
 		dtyp, err := c.ResolveTypeNode(d.Type)
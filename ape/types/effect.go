@@ -0,0 +1,108 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pcen/ape/ape/ast"
+	"github.com/pcen/ape/ape/token"
+)
+
+// Effect classifies what calling a function can do to the world outside of the
+// interpreter, ordered from least to most harmful inside a skip block
+type Effect int
+
+const (
+	Pure         Effect = iota // only computes a value
+	Reversible                 // changes state that a reversal restores
+	Irreversible               // changes state that a reversal cannot restore without an @undo
+)
+
+func (e Effect) String() string {
+	return [...]string{"pure", "reversible", "irreversible"}[e]
+}
+
+// nativeEffects are the effects of the interpreter's native functions, the file and
+// key-value store natives are reversible because they have builtin inverses
+var nativeEffects = map[string]Effect{
+	"println": Irreversible,
+	"read":    Pure,
+	"write":   Reversible,
+	"touch":   Reversible,
+	"delete":  Reversible,
+	"move":    Reversible,
+	"shell":   Irreversible,
+
+	"kv_open":   Pure,
+	"kv_get":    Pure,
+	"kv_has":    Pure,
+	"kv_put":    Reversible,
+	"kv_delete": Reversible,
+
+	// the compensating request of a call is declared with @undo
+	"http_get":    Pure,
+	"http_post":   Irreversible,
+	"http_put":    Irreversible,
+	"http_delete": Irreversible,
+
+	// a message cannot be taken back once another task may have seen it
+	"join":    Pure,
+	"channel": Pure,
+	"send":    Irreversible,
+	"recv":    Irreversible,
+}
+
+// a call in the chain from a function to the native that gives it its effect
+type effectCall struct {
+	name string
+	pos  token.Position
+}
+
+// functionEffect is the effect of a function along with the calls leading to the
+// native it comes from
+type functionEffect struct {
+	effect Effect
+	path   []effectCall
+}
+
+func (e functionEffect) pathStr() string {
+	calls := make([]string, 0, len(e.path))
+	for _, call := range e.path {
+		calls = append(calls, fmt.Sprintf("%v (%v)", call.name, call.pos))
+	}
+	return strings.Join(calls, " -> ")
+}
+
+// EffectOf returns the effect of calling the native or module function with the given name
+func (c *Checker) EffectOf(name string) Effect {
+	return c.effectOf(name).effect
+}
+
+func (c *Checker) effectOf(name string) functionEffect {
+	if effect, ok := nativeEffects[name]; ok {
+		return functionEffect{effect: effect}
+	}
+	return c.effects[name]
+}
+
+// checkCallEffect records the effect of calling the function named by ident in the
+// function being checked, and reports irreversible calls made inside a skip block
+func (c *Checker) checkCallEffect(call *ast.CallExpr, ident *ast.IdentExpr) {
+	callee := c.effectOf(ident.Ident.Lexeme)
+	if callee.effect == Irreversible && c.undone[call] {
+		// the @undo annotation on the call compensates for it
+		callee = functionEffect{effect: Reversible}
+	}
+	effect := functionEffect{
+		effect: callee.effect,
+		path:   append([]effectCall{{name: ident.Ident.Lexeme, pos: ident.Ident.Position}}, callee.path...),
+	}
+
+	if effect.effect == Irreversible && len(c.skips) > 0 {
+		skip := c.skips[len(c.skips)-1]
+		c.warn(ident.Ident.Position, "irreversible call without @undo in skip block: skip (%v) -> %v", skip.Token.Position, effect.pathStr())
+	}
+	if effect.effect > c.effect.effect {
+		c.effect = effect
+	}
+}
@@ -58,15 +58,55 @@ func (c *Checker) CheckExpr(expr ast.Expression) (t Type) {
 		for _, arg := range e.Args {
 			c.CheckExpr(arg)
 		}
+		// whatever escapes the function can happen here too
+		if ident, ok := e.Callee.(*ast.IdentExpr); ok {
+			if len(c.reverses) > 0 {
+				top := len(c.reverses) - 1
+				c.reverses[top] = append(c.reverses[top], c.escapes[ident.Ident.Lexeme]...)
+			}
+			c.checkCallEffect(e, ident)
+		}
+		// a call has the type of what the function returns
+		if fn, ok := t.(Function); ok && len(fn.Returns) == 1 {
+			t = fn.Returns[0]
+		}
+		if ident, ok := e.Callee.(*ast.IdentExpr); ok && len(e.Args) > 0 {
+			t = c.checkTaskCall(ident, e.Args, t)
+		}
+
+	case *ast.SpawnExpr:
+		// the task runs outside of any skip block the spawn is in, and a reverse that
+		// escapes the call fails the task rather than reversing the spawner
+		skips, reverses := c.skips, c.reverses
+		c.skips, c.reverses = nil, nil
+		result := c.CheckExpr(e.Call)
+		c.skips, c.reverses = skips, reverses
+		if len(c.skips) > 0 {
+			skip := c.skips[len(c.skips)-1]
+			c.warn(e.Token.Position, "spawned task is not reversed with its skip block: skip (%v) -> spawn (%v)", skip.Token.Position, e.Token.Position)
+		}
+		t = NewTask(result)
 
 	case *ast.DotExpr:
 		et := c.CheckExpr(e.Expr)
 		// the type of Field depends on the type of the receiver
-		switch et.(type) {
+		switch rt := et.(type) {
 		case List:
-			if e.Field.Ident.Lexeme == "push" {
+			switch e.Field.Ident.Lexeme {
+			case "push":
 				t = NewFunction(nil, nil)
+			case "pop":
+				t = NewFunction(nil, []Type{rt.Data})
+			case "len":
+				t = NewFunction(nil, []Type{Int})
+			}
+		case Named:
+			member, ok := c.classes[rt.String()][e.Field.Ident.Lexeme]
+			if !ok {
+				c.err(e.Field.Ident.Position, "%v has no member %v", rt, e.Field.Ident.Lexeme)
+				member = Invalid
 			}
+			t = member
 		default:
 			fmt.Println("WARNING: unknown receiver type in dot expression")
 			t = c.CheckExpr(e.Field)
@@ -121,3 +161,24 @@ func (c *Checker) CheckExpr(expr ast.Expression) (t Type) {
 	c.Types[expr] = t
 	return t
 }
+
+// checkTaskCall gives join and recv the type of the task or channel they are called with,
+// and checks that what is sent on a channel is what it carries
+func (c *Checker) checkTaskCall(ident *ast.IdentExpr, args []ast.Expression, t Type) Type {
+	switch ident.Ident.Lexeme {
+	case "join":
+		if task, ok := c.Types[args[0]].(Task); ok {
+			return task.Result
+		}
+	case "recv":
+		if ch, ok := c.Types[args[0]].(Chan); ok {
+			return ch.Elem
+		}
+	case "send":
+		ch, ok := c.Types[args[0]].(Chan)
+		if ok && len(args) > 1 && !ch.Elem.Is(Any) && !c.Types[args[1]].Is(ch.Elem) {
+			c.err(ident.Ident.Position, "cannot send %v on %v", c.Types[args[1]], ch)
+		}
+	}
+	return t
+}
@@ -0,0 +1,66 @@
+package types
+
+import (
+	"github.com/pcen/ape/ape/ast"
+	"github.com/pcen/ape/ape/token"
+)
+
+// checkReversible reports the statements in the body of a rev func that cannot be
+// inverted, which is everything but +=, -=, ^=, swaps, calls and ifs with a fi assertion.
+// Calls of other functions are left to the check that the rev func is pure
+func (c *Checker) checkReversible(fn *ast.FuncDecl, stmt ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.BlockStmt:
+		for _, stmt := range s.Content {
+			c.checkReversible(fn, stmt)
+		}
+
+	case *ast.AssignmentStmt:
+		switch s.Op.Kind {
+		case token.PlusEq, token.MinusEq, token.CaretEq:
+		default:
+			c.err(s.Op.Position, "%v is not reversible in rev func %v, only +=, -= and ^= are", s.Op.Kind, fn.Name.Lexeme)
+			return
+		}
+		// x -= x cannot be undone, since x is lost
+		if name := rootIdent(s.Lhs); name != "" && ast.Mentions(s.Rhs.(*ast.BinaryOp).Rhs, name) {
+			c.err(s.Op.Position, "%v cannot be updated with an expression that uses %v", s.Lhs.ExprStr(), name)
+		}
+
+	case *ast.SwapStmt, *ast.CallStmt:
+		break
+
+	case *ast.ExprStmt:
+		if _, ok := s.Expr.(*ast.CallExpr); !ok {
+			c.err(ast.Pos(s), "%v is not reversible in rev func %v", stmt.StmtStr(), fn.Name.Lexeme)
+		}
+
+	case *ast.IfStmt:
+		if len(s.Elifs) > 0 {
+			c.err(ast.Pos(s), "if with elifs in rev func %v cannot be reversed", fn.Name.Lexeme)
+		}
+		if s.Fi == nil {
+			c.err(ast.Pos(s), "if %v in rev func %v needs a fi assertion", s.If.Cond.ExprStr(), fn.Name.Lexeme)
+		}
+		c.checkReversible(fn, s.If.Body)
+		if s.Else != nil {
+			c.checkReversible(fn, s.Else)
+		}
+
+	default:
+		c.err(ast.Pos(stmt), "%v is not reversible in rev func %v", stmt.StmtStr(), fn.Name.Lexeme)
+	}
+}
+
+// rootIdent is the variable an assignable expression like xs[i] updates
+func rootIdent(expr ast.Expression) string {
+	switch e := expr.(type) {
+	case *ast.IdentExpr:
+		return e.Ident.Lexeme
+	case *ast.IndexExpr:
+		return rootIdent(e.Expr)
+	case *ast.DotExpr:
+		return rootIdent(e.Expr)
+	}
+	return ""
+}
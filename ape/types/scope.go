@@ -69,5 +69,26 @@ func GlobalScope() *Scope {
 	}
 	// TODO: properly define all builtin function signatures somewhere
 	scope.Symbols["println"] = NewFunction([]Type{Any}, []Type{Void})
+	scope.Symbols["read"] = NewFunction([]Type{String}, []Type{String})
+	scope.Symbols["write"] = NewFunction([]Type{String, String}, []Type{Void})
+	scope.Symbols["touch"] = NewFunction([]Type{String}, []Type{Void})
+	scope.Symbols["delete"] = NewFunction([]Type{Any}, []Type{Void})
+	scope.Symbols["move"] = NewFunction([]Type{String, String}, []Type{Void})
+	scope.Symbols["shell"] = NewFunction([]Type{String}, []Type{Void})
+	scope.Symbols["kv_open"] = NewFunction([]Type{String}, []Type{String})
+	scope.Symbols["kv_get"] = NewFunction([]Type{String, String}, []Type{String})
+	scope.Symbols["kv_has"] = NewFunction([]Type{String, String}, []Type{Bool})
+	scope.Symbols["kv_put"] = NewFunction([]Type{String, String, String}, []Type{Void})
+	scope.Symbols["kv_delete"] = NewFunction([]Type{String, String}, []Type{Void})
+	scope.Symbols["http_get"] = NewFunction([]Type{String}, []Type{String})
+	scope.Symbols["http_post"] = NewFunction([]Type{String, String}, []Type{String})
+	scope.Symbols["http_put"] = NewFunction([]Type{String, String}, []Type{String})
+	scope.Symbols["http_delete"] = NewFunction([]Type{String}, []Type{String})
+	// join, send and recv are typed by their arguments where they are called
+	scope.Symbols["join"] = NewFunction([]Type{Any}, []Type{Any})
+	scope.Symbols["channel"] = NewFunction([]Type{Int}, []Type{NewChan(Any)})
+	scope.Symbols["send"] = NewFunction([]Type{Any, Any}, []Type{Void})
+	scope.Symbols["recv"] = NewFunction([]Type{Any}, []Type{Any})
+	scope.Types[RuntimeError.String()] = RuntimeError
 	return scope
 }
@@ -16,10 +16,14 @@ func (c *Checker) CheckStatement(stmt ast.Statement) Type {
 		}
 
 	case *ast.TypedDeclStmt:
+		c.undoes(s.Decl.Value, s.Annotations["undo"])
 		c.CheckDeclaration(s.Decl)
+		c.checkCompensation(s.Decl.Value, s.Annotations["undo"])
 
 	case *ast.ExprStmt:
+		c.undoes(s.Expr, s.Annotations["undo"])
 		c.CheckExpr(s.Expr)
+		c.checkCompensation(s.Expr, s.Annotations["undo"])
 
 	case *ast.ForStmt:
 		c.pushScope()
@@ -61,6 +65,35 @@ func (c *Checker) CheckStatement(stmt ast.Statement) Type {
 		if s.Else != nil {
 			c.CheckStatement(s.Else)
 		}
+		if s.Fi != nil && !c.CheckExpr(s.Fi).Is(Bool) {
+			c.err(token.Position{}, "fi assertion must have boolean type")
+		}
+
+	case *ast.SwapStmt:
+		l := c.CheckExpr(s.Lhs)
+		r := c.CheckExpr(s.Rhs)
+		if !r.Is(l) {
+			c.err(s.Token.Position, "cannot swap %v with %v", l, r)
+		}
+
+	case *ast.CallStmt:
+		c.CheckExpr(s.Call)
+		if ident, ok := s.Call.Callee.(*ast.IdentExpr); !ok || !c.revs[ident.Ident.Lexeme] {
+			c.err(s.Token.Position, "%v of %v, which is not a rev func", s.Token.Kind, s.Call.Callee.ExprStr())
+		}
+		// arguments are passed by reference, so they must be distinct variables
+		passed := make(map[string]bool)
+		for _, arg := range s.Call.Args {
+			switch arg.(type) {
+			case *ast.IdentExpr, *ast.IndexExpr:
+			default:
+				c.err(s.Token.Position, "argument %v of %v must be a variable", arg.ExprStr(), s.Token.Kind)
+			}
+			if passed[arg.ExprStr()] {
+				c.err(s.Token.Position, "%v is passed to %v more than once", arg.ExprStr(), s.Token.Kind)
+			}
+			passed[arg.ExprStr()] = true
+		}
 
 	case *ast.CondBlockStmt:
 		if !c.CheckExpr(s.Cond).Is(Bool) {
@@ -87,47 +120,183 @@ func (c *Checker) CheckStatement(stmt ast.Statement) Type {
 		break
 
 	case *ast.SkipStmt:
-		var reverseType Type = nil
-		for _, bodyStmt := range s.Body.Content {
-			switch reverseStmt := bodyStmt.(type) {
-			case *ast.ReverseStmt:
-				// make sure the type reversed on is consistent throughout the current skip
-				// statement block
-				nextReverseType := c.CheckExpr(reverseStmt.Expr)
-				if reverseType != nil && !nextReverseType.Is(Void) && !nextReverseType.Is(reverseType) {
-					c.err(reverseStmt.Token.Position, "inconsistent reverse types in skip block")
-				} else {
-					reverseType = nextReverseType
-				}
-			default:
-				c.CheckStatement(bodyStmt)
+		// attempt counters are visible to the skip body and its seizes
+		c.pushScope()
+		for _, retry := range s.Retries {
+			if retry.Counter == nil {
+				continue
+			}
+			// several retries can share a counter
+			if _, ok := c.Scope.Symbols[retry.Counter.Ident.Lexeme]; ok {
+				continue
+			}
+			if err := c.Scope.DeclareSymbol(retry.Counter.Ident.Lexeme, Int); err != nil {
+				c.err(retry.Counter.Ident.Position, err.Error())
 			}
 		}
-		for _, seize := range s.Seizes {
-			// make sure that each seize statement seizes the same type as each reverse statement
-			// in the preceding skip statement block
-			accepts := c.CheckStatement(seize)
-			if reverseType != nil && !accepts.Is(Void) && !accepts.Is(reverseType) {
-				c.err(seize.Token.Position, "seize expr type does not match reverse expr type in skip block: %v is not %v", accepts, reverseType)
+		c.skips = append(c.skips, s)
+		c.reverses = append(c.reverses, nil)
+		c.undos = append(c.undos, nil)
+		c.CheckStatement(s.Body)
+		reversed := c.reverses[len(c.reverses)-1]
+		c.reverses = c.reverses[:len(c.reverses)-1]
+		c.skips = c.skips[:len(c.skips)-1]
+		// a finished skip's undos stay in the enclosing skip until it commits or ends
+		undos := c.undos[len(c.undos)-1]
+		c.undos = c.undos[:len(c.undos)-1]
+		if len(c.undos) > 0 {
+			c.undos[len(c.undos)-1] = append(c.undos[len(c.undos)-1], undos...)
+		}
+
+		// seize bodies are outside of the skip, so reverses in them reach the enclosing skip
+		accepts := make([]Type, len(s.Seizes))
+		for i, seize := range s.Seizes {
+			accepts[i] = c.CheckStatement(seize)
+			if seize.Name == nil && seize.Expr == nil {
+				continue
+			}
+			// any skip can be reversed by a runtime error
+			matched := accepts[i].Is(RuntimeError)
+			for _, rev := range reversed {
+				matched = matched || accepts[i].Is(rev.typ)
+			}
+			if !matched {
+				c.err(seize.Token.Position, "seize type %v does not match any reverse type in skip block", accepts[i])
 			}
 		}
 
+		// seizing a value only handles a reverse of that same constant, so every other reverse must
+		// be seized by type or by a catch all seize, or else it is propagated to the enclosing skip or function
+		for _, rev := range reversed {
+			handled := false
+			for i, seize := range s.Seizes {
+				catchAll := seize.Name == nil && seize.Expr == nil
+				handled = handled || catchAll || (seize.Name != nil && accepts[i].Is(rev.typ)) || sameConstant(rev.value, seize.Expr)
+			}
+			if !handled && len(c.reverses) > 0 {
+				c.reverses[len(c.reverses)-1] = append(c.reverses[len(c.reverses)-1], rev)
+			}
+		}
+		// like the seizes, the always clause is outside of the skip
+		if s.Always != nil {
+			c.CheckStatement(s.Always)
+		}
+		c.popScope()
+
+	case *ast.RetryStmt:
+		if s.Max != nil && !c.CheckExpr(s.Max).Is(Int) {
+			c.err(s.Token.Position, "retry attempts must be an int")
+		}
+
 	case *ast.ReverseStmt:
-		if s.Expr == nil {
+		if s.To != nil {
+			if len(c.skips) == 0 {
+				c.err(s.Token.Position, "reverse to outside of a skip block")
+			}
+			if t := c.CheckExpr(s.To); !t.Is(String) {
+				c.err(s.Token.Position, "savepoint name must be a string, got %v", t)
+			}
 			return Void
 		}
-		return c.CheckExpr(s.Expr)
+		var typ Type = Void
+		if s.Expr != nil {
+			typ = c.CheckExpr(s.Expr)
+		}
+		if len(c.reverses) > 0 {
+			rev := reverseSite{pos: s.Token.Position, typ: typ}
+			if lit, ok := s.Expr.(*ast.LiteralExpr); ok {
+				rev.value = &lit.Token
+			}
+			c.reverses[len(c.reverses)-1] = append(c.reverses[len(c.reverses)-1], rev)
+		}
+		return typ
+
+	case *ast.CommitStmt:
+		if len(c.skips) == 0 {
+			c.err(s.Token.Position, "commit outside of a skip block")
+			break
+		}
+		for _, call := range c.undos[len(c.undos)-1] {
+			c.warn(s.Token.Position, "commit makes @undo of %v irrevocable", call.ExprStr())
+		}
+		c.undos[len(c.undos)-1] = nil
+
+	case *ast.SavepointStmt:
+		if len(c.skips) == 0 {
+			c.err(s.Token.Position, "savepoint outside of a skip block")
+		}
+		if t := c.CheckExpr(s.Name); !t.Is(String) {
+			c.err(s.Token.Position, "savepoint name must be a string, got %v", t)
+		}
 
 	case *ast.SeizeStmt:
 		var accepts Type = Void
-		if s.Expr != nil {
+		c.pushScope()
+		if s.Name != nil {
+			typ, err := c.ResolveTypeNode(s.Type)
+			if err != nil {
+				c.err(s.Name.Ident.Position, "invalid type %v for %v", s.Type, s.Name.Ident.Lexeme)
+			} else if err := c.Scope.DeclareSymbol(s.Name.Ident.Lexeme, typ); err != nil {
+				c.err(s.Name.Ident.Position, err.Error())
+			}
+			accepts = typ
+		} else if s.Expr != nil {
 			accepts = c.CheckExpr(s.Expr)
 		}
 		c.CheckStatement(s.Body)
+		c.popScope()
 		return accepts
 
+	case *ast.ReturnStmt:
+		var typ Type = Void
+		if s.Expr != nil {
+			typ = c.CheckExpr(s.Expr)
+		}
+		if !typ.Is(c.returns) {
+			c.err(ast.Pos(s), "cannot return %v from a function returning %v", typ, c.returns)
+		}
+
 	default:
 		panic("cannot check statement " + s.StmtStr() + ", " + reflect.TypeOf(stmt).String())
 	}
 	return Void
 }
+
+// sameConstant reports whether a seize of expr handles a reverse of the constant value
+func sameConstant(value *token.Token, expr ast.Expression) bool {
+	lit, ok := expr.(*ast.LiteralExpr)
+	return ok && value != nil && lit.Kind == value.Kind && lit.Lexeme == value.Lexeme
+}
+
+// undoes records that the call in forward is compensated by an @undo annotation
+func (c *Checker) undoes(forward ast.Expression, undo ast.Statement) {
+	call, ok := forward.(*ast.CallExpr)
+	if !ok || undo == nil {
+		return
+	}
+	c.undone[call] = true
+	if len(c.undos) > 0 {
+		c.undos[len(c.undos)-1] = append(c.undos[len(c.undos)-1], call)
+	}
+}
+
+// checkCompensation types the arguments of an @undo call, in which it is the value of forward.
+// They are typed so that code generation can save them
+func (c *Checker) checkCompensation(forward ast.Expression, undo ast.Statement) {
+	stmt, ok := undo.(*ast.ExprStmt)
+	if !ok {
+		return
+	}
+	call, ok := stmt.Expr.(*ast.CallExpr)
+	if !ok {
+		return
+	}
+	c.pushScope()
+	if it := c.Types[forward]; it != nil && !it.Is(Void) {
+		c.Scope.DeclareSymbol("it", it)
+	}
+	for _, arg := range call.Args {
+		c.CheckExpr(arg)
+	}
+	c.popScope()
+}
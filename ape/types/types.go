@@ -100,6 +100,13 @@ func (n Named) Is(other Type) bool {
 	return false
 }
 
+// RuntimeError is the builtin class that skip blocks are reversed with when a
+// runtime error happens in them
+var (
+	RuntimeError        = NewNamed("RuntimeError")
+	runtimeErrorMembers = map[string]Type{"message": String, "line": Int, "column": Int}
+)
+
 type Function struct {
 	Params  []Type
 	Returns []Type
@@ -184,12 +191,59 @@ func (m Map) Underlying() Type {
 	return m
 }
 
+// Task is the type of spawn f(x), joining it gives what f returns
+type Task struct {
+	Result Type
+}
+
+func NewTask(result Type) Type {
+	return Task{Result: result}
+}
+
+func (t Task) Is(other Type) bool {
+	o, ok := other.(Task)
+	return ok && t.Result.Is(o.Result)
+}
+
+func (t Task) String() string {
+	return fmt.Sprintf("task %v", t.Result)
+}
+
+func (t Task) Underlying() Type {
+	return t
+}
+
+// Chan is the type of a channel, a channel made by channel(n) carries any type until it
+// is given a declared type
+type Chan struct {
+	Elem Type
+}
+
+func NewChan(elem Type) Type {
+	return Chan{Elem: elem}
+}
+
+func (c Chan) Is(other Type) bool {
+	o, ok := other.(Chan)
+	return ok && (c.Elem.Is(Any) || o.Elem.Is(Any) || c.Elem.Is(o.Elem))
+}
+
+func (c Chan) String() string {
+	return fmt.Sprintf("chan %v", c.Elem)
+}
+
+func (c Chan) Underlying() Type {
+	return c
+}
+
 // assert all types implement Type interface
 var (
 	_ Type = Invalid
 	_ Type = Named{}
 	_ Type = Function{}
 	_ Type = List{}
+	_ Type = Task{}
+	_ Type = Chan{}
 )
 
 var (
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pcen/ape/ape"
+	"github.com/pcen/ape/ape/interpreter"
+)
+
+const help = `commands:
+  s, step               run the current statement
+  b, back               go back to before the previous statement
+  c, continue           run forward to the next breakpoint
+  rc, reverse-continue  go back to the previous breakpoint
+  break <line>          set a breakpoint
+  delete <line>         remove a breakpoint
+  p, print              print the scope chain
+  q, quit               stop debugging`
+
+// usage:
+//
+//	debug [-b line,line...] <file>   step through main forwards and backwards
+func main() {
+	lines := flag.String("b", "", "comma separated lines to break at")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		fmt.Println("supply file to debug")
+		os.Exit(1)
+	}
+	file := flag.Arg(0)
+	source, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	sourceLines := strings.Split(string(source), "\n")
+
+	breakpoints := make(map[uint]bool)
+	for _, line := range strings.Split(*lines, ",") {
+		if n, err := strconv.ParseUint(strings.TrimSpace(line), 10, 32); err == nil {
+			breakpoints[uint(n)] = true
+		}
+	}
+
+	parser := ape.NewParser(ape.NewLexer().LexFile(file))
+	prog := parser.Program()
+	if errors, ok := parser.Errors(); ok {
+		for _, err := range errors {
+			fmt.Println(err)
+		}
+		os.Exit(1)
+	}
+	twi := interpreter.NewTWI()
+	for _, decl := range prog {
+		twi.Interpret(decl)
+	}
+
+	debugger := interpreter.NewDebugger(twi)
+	debugger.Start()
+	if len(breakpoints) > 0 && !breakpoints[debugger.Current().Pos.Line] {
+		debugger.Continue(breakpoints)
+	}
+	show(debugger, sourceLines)
+
+	input := bufio.NewScanner(os.Stdin)
+	for fmt.Print("(debug) "); input.Scan(); fmt.Print("(debug) ") {
+		fields := strings.Fields(input.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "s", "step":
+			if !debugger.Forward() {
+				fmt.Println("main has returned")
+				continue
+			}
+		case "b", "back":
+			if !debugger.Back() {
+				fmt.Println("at the first statement")
+				continue
+			}
+		case "c", "continue":
+			debugger.Continue(breakpoints)
+		case "rc", "reverse-continue":
+			debugger.ReverseContinue(breakpoints)
+		case "break", "delete":
+			if len(fields) < 2 {
+				fmt.Printf("%v needs a line\n", fields[0])
+				continue
+			}
+			n, err := strconv.ParseUint(fields[1], 10, 32)
+			if err != nil {
+				fmt.Printf("invalid line %v\n", fields[1])
+				continue
+			}
+			if fields[0] == "break" {
+				breakpoints[uint(n)] = true
+			} else {
+				delete(breakpoints, uint(n))
+			}
+			continue
+		case "p", "print":
+		case "q", "quit":
+			return
+		default:
+			fmt.Println(help)
+			continue
+		}
+		show(debugger, sourceLines)
+	}
+}
+
+// show prints the statement the program is stopped at, followed by the scope chain it runs in
+func show(debugger *interpreter.Debugger, source []string) {
+	step := debugger.Current()
+	if debugger.Finished() {
+		if debugger.Err != nil {
+			fmt.Println(debugger.Err)
+		}
+		fmt.Println("main has returned")
+	} else {
+		line := ""
+		if n := int(step.Pos.Line); n > 0 && n <= len(source) {
+			line = strings.TrimSpace(source[n-1])
+		}
+		fmt.Printf("%v:%v  %v\n", step.Func, step.Pos.Line, line)
+	}
+	// innermost scope first, empty block scopes are left out
+	depth := 0
+	for scope := step.Scope; scope != nil; scope = scope.Enclosing {
+		if vars := scope.String(); vars != "" {
+			fmt.Printf("  %v%v\n", strings.Repeat("  ", depth), vars)
+			depth++
+		}
+	}
+}
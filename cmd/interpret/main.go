@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 
@@ -8,11 +9,26 @@ import (
 	"github.com/pcen/ape/ape/interpreter"
 )
 
+// usage:
+//
+//	interpret [-journal] [-buffer [-reverted]] <file>   run main, journaling skip blocks next to the file
+//	interpret recover <file>      run the compensations left pending by a crashed run
 func main() {
-	if len(os.Args) < 2 {
+	journal := flag.Bool("journal", false, "write skip blocks to an undo journal next to the script")
+	buffer := flag.Bool("buffer", false, "hold output inside skip blocks until the outermost one finishes without reversing")
+	reverted := flag.Bool("reverted", false, "with -buffer, write output dropped by reversals to stderr")
+	flag.Parse()
+
+	args := flag.Args()
+	recoverMode := len(args) > 1 && args[0] == "recover"
+	if recoverMode {
+		args = args[1:]
+	}
+	if len(args) < 1 {
 		fmt.Println("supply file to parse")
+		os.Exit(1)
 	}
-	file := os.Args[1]
+	file := args[0]
 	lexer := ape.NewLexer()
 	tokens := lexer.LexFile(file)
 
@@ -22,12 +38,46 @@ func main() {
 	// ast.PrettyPrint(prog)
 
 	twi := interpreter.NewTWI()
+	twi.BufferOutput = *buffer
+	if *reverted {
+		twi.Reverted = os.Stderr
+	}
 
 	for _, decl := range prog {
 		twi.Interpret(decl)
 	}
 
-	twi.RunMain()
+	journalPath := interpreter.JournalPath(file)
+	if recoverMode {
+		if err := twi.Recover(journalPath); err != nil {
+			fmt.Println("error recovering:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *journal {
+		if pending, err := interpreter.JournalPending(journalPath); err != nil || pending {
+			fmt.Printf("%v has unfinished skip blocks, run \"interpret recover %v\" first\n", journalPath, file)
+			os.Exit(1)
+		}
+		j, err := interpreter.OpenJournal(journalPath)
+		if err != nil {
+			fmt.Println("error opening journal:", err)
+			os.Exit(1)
+		}
+		twi.Journal = j
+	}
+
+	runErr := twi.RunMain()
+
+	if twi.Journal != nil {
+		twi.Journal.Remove()
+	}
+	if runErr != nil {
+		fmt.Println(runErr)
+		os.Exit(1)
+	}
 
 	if errors, ok := parser.Errors(); ok {
 		for _, err := range errors {
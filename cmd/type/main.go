@@ -1,23 +1,39 @@
 package main
 
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pcen/ape/ape"
+	"github.com/pcen/ape/ape/types"
+)
+
+// usage:
+//
+//	type [-strict] <file>   type check a module, in strict mode warnings are errors
 func main() {
-	// if len(os.Args) < 2 {
-	// 	fmt.Println("supply file to parse")
-	// }
-	// file := os.Args[1]
-	// lexer := ape.NewLexer()
-	// tokens := lexer.LexFile(file)
-	// parser := ape.NewParser(tokens)
-	// prog := parser.Program()
+	strict := flag.Bool("strict", false, "report warnings, such as irreversible calls in skip blocks, as errors")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		fmt.Println("supply file to check")
+		os.Exit(1)
+	}
+	tokens := ape.NewLexer().LexFile(flag.Arg(0))
+	parser := ape.NewParser(tokens)
+	file := parser.File()
+	if errors, ok := parser.Errors(); ok {
+		for _, err := range errors {
+			fmt.Println(err)
+		}
+		os.Exit(1)
+	}
 
-	// checker := types.NewChecker()
-	// typeMap, symbolTable := checker.Check(prog)
-	// fmt.Println("expression node types:")
-	// for key, val := range typeMap {
-	// 	fmt.Printf("%+v: %v\n", key, val)
-	// }
-	// fmt.Println("\nsymbol table:")
-	// for key, val := range symbolTable {
-	// 	fmt.Printf("%v: %v\n", key, val)
-	// }
+	checker := types.NewChecker(file)
+	checker.Strict = *strict
+	checker.Check()
+	if len(checker.Errors) > 0 {
+		os.Exit(1)
+	}
 }
@@ -44,6 +44,30 @@ var (
 			"switches.ape",
 			"3\n2\n1",
 		},
+		{
+			"skip_seize.ape",
+			"Enter foo with rev_type a\nIn inner seize a with x 3\nContinuing in skip with x 3\nExiting foo with 4\n" +
+				"Enter foo with rev_type b\nIn inner seize b\nIn seize b with x 1\nExiting foo with 2\n" +
+				"Enter foo with rev_type c\nIn seize c\nExiting foo with 0\n420",
+		},
+		{
+			"reverse_annotation.ape",
+			"set 1\nset 2\nundo 2 with a 109\nundo 1 with a 109\nreversed DEFAULT to 10 100\nset 3\nundo 3 with a 1\nseized 7 10 100\n" +
+				"create 1\ncreate 2\ndestroy 3\ndestroy 1\nseized 1 next 0",
+		},
+		{
+			"airplane.ape",
+			"CHARGE: reenus\nRESERVE SEAT: reenus\nbooked: True\nCHARGE: alex\nRESERVE SEAT: alex\nFREE SEAT: alex\nREFUND: alex\n" +
+				"side effects were undone\nbooked: False\nseats: 0",
+		},
+		{
+			"always.ape",
+			"always sees log 1\nreturned 7 log 12\n" +
+				"seize 1 sees log 0\nalways sees log 0\nreturned 8 log 2\n" +
+				"seize sees log 0\nalways sees log 0\nafter skip log 2\nreturned 9 log 2\n" +
+				"inner always sees log 0\nouter seized out log 0\n" +
+				"always after seize sees log 3\nseized 2 log 0",
+		},
 	}
 )
 